@@ -3,19 +3,157 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
 	"github.com/sashabaranov/go-openai"
 )
 
-type AIClient struct {
+type aiKey struct {
+	APIKeyConfig
+	// client is created when the key is first used, so that secrets are only resolved when needed.
 	client *openai.Client
-	model  string
 }
 
-func NewAIClient(apiKey, model string) *AIClient {
-	return &AIClient{
-		client: openai.NewClient(apiKey),
-		model:  model,
+type AIClient struct {
+	provider string
+	baseURL  string
+	keys     []*aiKey
+	current  int
+	model    string
+	// ServedBy is the label of the key that served the last request.
+	ServedBy string
+}
+
+func NewAIClient(provider, baseURL string, keys []APIKeyConfig, model string) *AIClient {
+	ai := &AIClient{
+		provider: provider,
+		baseURL:  baseURL,
+		model:    model,
+	}
+	for _, key := range keys {
+		ai.keys = append(ai.keys, &aiKey{APIKeyConfig: key})
+	}
+	return ai
+}
+
+// newConfiguredAIClient creates a client for the active provider of the config.
+func newConfiguredAIClient(config Config, model string) *AIClient {
+	provider, providerConfig := config.ActiveProvider()
+	baseURL := firstNonEmpty(providerConfig.BaseURL, defaultBaseURLs[provider])
+	if violation := loadPolicy().CheckModel(provider, baseURL, model); violation != nil {
+		violation.Fatal()
+	}
+	return NewAIClient(provider, providerConfig.BaseURL, getAPIKeys(), model)
+}
+
+// newSingleKeyAIClient creates a client for a key that is already resolved.
+func newSingleKeyAIClient(provider, baseURL, apiKey, model string) *AIClient {
+	ai := NewAIClient(provider, baseURL, nil, model)
+	ai.keys = []*aiKey{{
+		APIKeyConfig: APIKeyConfig{Label: "default"},
+		client:       openai.NewClientWithConfig(ai.clientConfig(apiKey)),
+	}}
+	return ai
+}
+
+func (ai *AIClient) clientConfig(apiKey string) openai.ClientConfig {
+	if ai.provider == ProviderAzure {
+		return openai.DefaultAzureConfig(apiKey, ai.baseURL)
+	}
+	config := openai.DefaultConfig(apiKey)
+	if ai.baseURL != "" {
+		config.BaseURL = ai.baseURL
+	}
+	return config
+}
+
+func (ai *AIClient) keyClient(key *aiKey) (*openai.Client, error) {
+	if key.client == nil {
+		apiKey, err := resolveSecret(key.APIKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolving API key %q", key.Label)
+		}
+		key.client = openai.NewClientWithConfig(ai.clientConfig(apiKey))
+	}
+	return key.client, nil
+}
+
+// withKeyRotation calls request with the current key. On authentication errors and exhausted
+// quotas it rotates to the next key; keys that exceeded their monthly cap are skipped. Rate
+// limits are retried with the same key after a delay.
+func (ai *AIClient) withKeyRotation(request func(client *openai.Client) error) error {
+	var lastErr error
+	for ; ai.current < len(ai.keys); ai.current++ {
+		key := ai.keys[ai.current]
+		if key.MonthlyCap > 0 {
+			spend := monthlySpend(key.Label)
+			if spend >= key.MonthlyCap {
+				lastErr = fmt.Errorf("API key %q reached its monthly cap ($%.2f of $%.2f)", key.Label, spend, key.MonthlyCap)
+				fmt.Fprintln(os.Stderr, lastErr)
+				continue
+			}
+		}
+		client, err := ai.keyClient(key)
+		if err != nil {
+			return err
+		}
+
+		err = request(client)
+		for attempt := 0; attempt < len(rateLimitDelays) && isRateLimitError(err); attempt++ {
+			fmt.Fprintf(os.Stderr, "API key %q is rate limited, retrying in %s\n", key.Label, rateLimitDelays[attempt])
+			time.Sleep(rateLimitDelays[attempt])
+			err = request(client)
+		}
+		if err == nil {
+			ai.ServedBy = key.Label
+			return nil
+		}
+		if !isKeyRotationError(err) {
+			return err
+		}
+		lastErr = err
+		if ai.current+1 < len(ai.keys) {
+			fmt.Fprintf(os.Stderr, "API key %q was rejected (%v), trying the next key\n", key.Label, err)
+		}
+	}
+	if lastErr == nil {
+		return errors.New("no API keys configured")
 	}
+	return errors.Wrap(lastErr, "all API keys are exhausted")
+}
+
+// rateLimitDelays are the waits before retrying a rate limited request.
+var rateLimitDelays = []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second}
+
+// isKeyRotationError tells whether the key was rejected, or is out of quota. A 429 is only an
+// exhausted quota with the insufficient_quota code; otherwise it is a rate limit.
+func isKeyRotationError(err error) bool {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode == 401 || apiErr.HTTPStatusCode == 429 && isQuotaError(apiErr)
+	}
+	var requestErr *openai.RequestError
+	if errors.As(err, &requestErr) {
+		return requestErr.HTTPStatusCode == 401
+	}
+	return false
+}
+
+func isRateLimitError(err error) bool {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode == 429 && !isQuotaError(apiErr)
+	}
+	var requestErr *openai.RequestError
+	return errors.As(err, &requestErr) && requestErr.HTTPStatusCode == 429
+}
+
+func isQuotaError(apiErr *openai.APIError) bool {
+	return apiErr.Code == "insufficient_quota" || apiErr.Type == "insufficient_quota"
 }
 
 func (ai *AIClient) ChatCompletion(messages []Message) (string, error) {
@@ -27,22 +165,95 @@ func (ai *AIClient) ChatCompletion(messages []Message) (string, error) {
 		})
 	}
 
-	resp, err := ai.client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model:    ai.model,
-			Messages: openaiMessages,
-		},
-	)
+	var resp openai.ChatCompletionResponse
+	err := ai.withKeyRotation(func(client *openai.Client) error {
+		var err error
+		resp, err = client.CreateChatCompletion(
+			context.Background(),
+			openai.ChatCompletionRequest{
+				Model:    ai.model,
+				Messages: openaiMessages,
+			},
+		)
+		return err
+	})
 
 	if err != nil {
 		return "", err
 	}
+	usage := resp.Usage
+	if usage.TotalTokens == 0 && len(resp.Choices) > 0 {
+		usage = estimateUsage(messages, resp.Choices[0].Message.Content)
+	}
+	recordUsage(ai.ServedBy, ai.model, usage)
 
 	return resp.Choices[0].Message.Content, nil
 }
 
-func (ai *AIClient) ChatCompletionStream(messages []Message) (*openai.ChatCompletionStream, error) {
+// ChatStream wraps the completion stream to record the token usage of the key that served it.
+// Without usage from the provider, or when the stream is closed before its end, the usage is
+// estimated from the length of the messages and the response.
+type ChatStream struct {
+	stream   *openai.ChatCompletionStream
+	ai       *AIClient
+	messages []Message
+	usage    *openai.Usage
+	response strings.Builder
+	recorded bool
+}
+
+// Recv returns the next chunk that has a choice. The usage-only chunk at the end is consumed.
+func (s *ChatStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	for {
+		response, err := s.stream.Recv()
+		if err != nil {
+			s.recordUsage()
+			return response, err
+		}
+		if response.Usage != nil {
+			s.usage = response.Usage
+		}
+		for _, choice := range response.Choices {
+			s.response.WriteString(choice.Delta.Content)
+			if choice.Delta.FunctionCall != nil {
+				s.response.WriteString(choice.Delta.FunctionCall.Arguments)
+			}
+		}
+		if len(response.Choices) > 0 {
+			return response, nil
+		}
+	}
+}
+
+func (s *ChatStream) recordUsage() {
+	if s.recorded {
+		return
+	}
+	s.recorded = true
+	usage := estimateUsage(s.messages, s.response.String())
+	if s.usage != nil {
+		usage = *s.usage
+	}
+	recordUsage(s.ai.ServedBy, s.ai.model, usage)
+}
+
+func (s *ChatStream) Close() error {
+	s.recordUsage()
+	return s.stream.Close()
+}
+
+// estimateUsage estimates the tokens of a request at about four characters per token.
+func estimateUsage(messages []Message, response string) openai.Usage {
+	prompt := 0
+	for _, message := range messages {
+		prompt += len(message.Content)
+	}
+	usage := openai.Usage{PromptTokens: (prompt + 3) / 4, CompletionTokens: (len(response) + 3) / 4}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
+}
+
+func (ai *AIClient) ChatCompletionStream(messages []Message) (*ChatStream, error) {
 	var oaiMessages []openai.ChatCompletionMessage
 	for _, msg := range messages {
 		oaiMessages = append(oaiMessages, openai.ChatCompletionMessage{
@@ -66,6 +277,13 @@ func (ai *AIClient) ChatCompletionStream(messages []Message) (*openai.ChatComple
 						"type": "string"
 					},
 					"description": "List of required binaries for the command"
+				},
+				"modified_paths": {
+					"type": "array",
+					"items": {
+						"type": "string"
+					},
+					"description": "Files and directories that the command creates, modifies, moves or deletes, relative to the working directory. Globs like *.txt are allowed"
 				}
 			},
 			"required": ["command"]
@@ -80,13 +298,39 @@ func (ai *AIClient) ChatCompletionStream(messages []Message) (*openai.ChatComple
 		Stream:       true,
 		Functions:    []openai.FunctionDefinition{returnCommandFunction},
 		FunctionCall: openai.FunctionCall{Name: "return_command"},
+		// Providers that support it send the usage in a last chunk.
+		StreamOptions: &openai.StreamOptions{IncludeUsage: true},
 	}
 
-	return ai.client.CreateChatCompletionStream(ctx, req)
+	var stream *openai.ChatCompletionStream
+	err := ai.withKeyRotation(func(client *openai.Client) error {
+		var err error
+		stream, err = client.CreateChatCompletionStream(ctx, req)
+		if isUnsupportedStreamOptionsError(err) {
+			// Older Azure API versions and some compatible servers reject stream_options.
+			req.StreamOptions = nil
+			stream, err = client.CreateChatCompletionStream(ctx, req)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ChatStream{stream: stream, ai: ai, messages: messages}, nil
+}
+
+func isUnsupportedStreamOptionsError(err error) bool {
+	var apiErr *openai.APIError
+	return errors.As(err, &apiErr) && apiErr.HTTPStatusCode == 400 && strings.Contains(apiErr.Message, "stream_options")
 }
 
 func (ai *AIClient) GetAvailableModels() ([]string, error) {
-	modelList, err := ai.client.ListModels(context.Background())
+	var modelList openai.ModelsList
+	err := ai.withKeyRotation(func(client *openai.Client) error {
+		var err error
+		modelList, err = client.ListModels(context.Background())
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
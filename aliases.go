@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ShellAlias is an alias or function defined in the user's interactive shell.
+type ShellAlias struct {
+	Name string `json:"name"`
+	// Definition is empty for functions.
+	Definition string `json:"definition"`
+	Function   bool   `json:"function"`
+	// Shadows is set when the name hides a command of the same name on PATH.
+	Shadows bool `json:"-"`
+}
+
+// aliasListingScripts list the aliases, then aliasListingSeparator, then the function names one
+// per line, all in one run of the interactive shell.
+var aliasListingScripts = map[string]string{
+	"bash": "alias; echo " + aliasListingSeparator + "; compgen -A function",
+	"zsh":  "alias; echo " + aliasListingSeparator + "; print -l ${(k)functions}",
+	"fish": "alias; echo " + aliasListingSeparator + "; functions -n | string join \\n",
+}
+
+const aliasListingSeparator = "ai-functions:"
+
+var rcFiles = []string{".bashrc", ".bash_aliases", ".bash_profile", ".zshrc", ".config/fish/config.fish"}
+
+const (
+	aliasesTimeout  = 3 * time.Second
+	aliasesCacheTTL = time.Hour
+	maxAliases      = 40
+)
+
+var aliasWordRegex = regexp.MustCompile(`[A-Za-z0-9_.-]+`)
+
+// gatherAliases returns the aliases and functions that matter for the request: those that shadow
+// a command on PATH, and those named in the user's input.
+func gatherAliases(request ContextRequest) (interface{}, error) {
+	script, ok := aliasListingScripts[request.Shell]
+	if !ok {
+		return nil, nil
+	}
+
+	aliases := readShellIntegrationAliases()
+	if aliases == nil {
+		aliases = readShellAliasesCached(request.Shell, script)
+	}
+
+	inputWords := map[string]bool{}
+	for _, word := range aliasWordRegex.FindAllString(request.UserInput, -1) {
+		inputWords[word] = true
+	}
+	var relevant []ShellAlias
+	for _, alias := range aliases {
+		if strings.HasPrefix(alias.Name, "_") || strings.HasPrefix(alias.Name, "fish_") {
+			continue
+		}
+		_, err := exec.LookPath(alias.Name)
+		alias.Shadows = err == nil
+		if alias.Shadows || inputWords[alias.Name] {
+			relevant = append(relevant, alias)
+		}
+		if len(relevant) == maxAliases {
+			break
+		}
+	}
+	if len(relevant) == 0 {
+		return nil, nil
+	}
+	return relevant, nil
+}
+
+// readShellIntegrationAliases reads aliases passed by a shell wrapper function through
+// AI_SHELL_ALIASES and AI_SHELL_FUNCTIONS. It returns nil when they are not set.
+func readShellIntegrationAliases() []ShellAlias {
+	aliases, aliasesSet := os.LookupEnv("AI_SHELL_ALIASES")
+	functions, functionsSet := os.LookupEnv("AI_SHELL_FUNCTIONS")
+	if !aliasesSet && !functionsSet {
+		return nil
+	}
+	return parseShellAliases(aliases, functions)
+}
+
+// readShellAliasesCached starts the shell interactively to list its aliases. The result is
+// cached until the shell's rc files change. When the shell doesn't finish in time, what it listed
+// so far is cached, so that slow rc files don't delay every request.
+func readShellAliasesCached(shell string, script string) []ShellAlias {
+	key := shell
+	for _, rcFile := range rcFiles {
+		if info, err := os.Stat(filepath.Join(homeDir, rcFile)); err == nil {
+			key += "|" + rcFile + "@" + info.ModTime().String()
+		}
+	}
+	var aliases []ShellAlias
+	if readCache("aliases-"+shell, key, aliasesCacheTTL, &aliases) {
+		return aliases
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), aliasesTimeout)
+	defer cancel()
+	// Output returns what the shell wrote before it failed or was killed.
+	output, _ := exec.CommandContext(ctx, shell, "-i", "-c", script).Output()
+	aliasOutput, functionOutput, _ := strings.Cut(string(output), aliasListingSeparator+"\n")
+
+	aliases = parseShellAliases(aliasOutput, functionOutput)
+	writeCache("aliases-"+shell, key, aliases)
+	return aliases
+}
+
+// parseShellAliases parses the output of `alias` in bash (alias ll='ls -l'), zsh (ll='ls -l')
+// and fish (alias ll 'ls -l'), and a list of function names.
+func parseShellAliases(aliasOutput, functionOutput string) []ShellAlias {
+	aliases := []ShellAlias{}
+	seen := map[string]bool{}
+	for _, line := range strings.Split(aliasOutput, "\n") {
+		line = strings.TrimPrefix(strings.TrimSpace(line), "alias ")
+		if line == "" {
+			continue
+		}
+		name, definition, found := strings.Cut(line, "=")
+		if !found || strings.ContainsAny(name, " \t") {
+			name, definition, found = strings.Cut(line, " ")
+			if !found {
+				continue
+			}
+		}
+		definition = unquoteShellWord(strings.TrimSpace(definition))
+		if !seen[name] {
+			seen[name] = true
+			aliases = append(aliases, ShellAlias{Name: name, Definition: definition})
+		}
+	}
+	for _, name := range strings.Fields(strings.ReplaceAll(functionOutput, ",", " ")) {
+		if !seen[name] {
+			seen[name] = true
+			aliases = append(aliases, ShellAlias{Name: name, Function: true})
+		}
+	}
+	return aliases
+}
+
+func unquoteShellWord(word string) string {
+	if len(word) >= 2 && (word[0] == '\'' || word[0] == '"') && word[len(word)-1] == word[0] {
+		word = word[1 : len(word)-1]
+	}
+	return strings.ReplaceAll(word, `'\''`, `'`)
+}
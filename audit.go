@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// AuditEntry records what happened to a request. Entries are written to an append-only log, in
+// which each line includes the hash of the previous line, so that edits and deletions in the
+// middle of the log can be detected.
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	User       string    `json:"user"`
+	Cwd        string    `json:"cwd"`
+	Model      string    `json:"model"`
+	PromptHash string    `json:"prompt_hash"`
+	Command    string    `json:"command"`
+	Risk       string    `json:"risk,omitempty"`
+	// Action is one of the Audit* actions.
+	Action   string `json:"action"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+	// Error tells why a request or command failed.
+	Error string `json:"error,omitempty"`
+}
+
+const (
+	AuditTyped     = "typed"
+	AuditExecuted  = "executed"
+	AuditDiscarded = "discarded"
+	AuditBlocked   = "blocked"
+	AuditAnswered  = "answered"
+	// AuditFailed is a request without a response, or a command that couldn't be started.
+	AuditFailed = "failed"
+)
+
+// auditLine is a line of the log. Hash is the SHA-256 of Prev and Entry as written, and Prev
+// is the hash of the line before it.
+type auditLine struct {
+	Entry json.RawMessage `json:"entry"`
+	Prev  string          `json:"prev"`
+	Hash  string          `json:"hash"`
+}
+
+var auditFilePath = filepath.Join(stateDir, "audit.jsonl")
+
+// AuditRecord collects the details of a request that are the same for each of its entries.
+type AuditRecord struct {
+	// aiClient is the client that serves the request; entries record its model.
+	aiClient   *AIClient
+	promptHash string
+	redactor   *Redactor
+}
+
+// newAuditRecord hashes the messages as sent. Commands are logged with the secrets that the
+// redactor finds replaced by placeholders.
+func newAuditRecord(aiClient *AIClient, messages []Message, redactor *Redactor) *AuditRecord {
+	data, _ := json.Marshal(messages)
+	hash := sha256.Sum256(data)
+	return &AuditRecord{aiClient: aiClient, promptHash: hex.EncodeToString(hash[:]), redactor: redactor}
+}
+
+// Record appends an entry for a command. For executed commands, err is the result of running it;
+// an executed command that couldn't be started is recorded as failed. For failed requests, err
+// is the reason.
+func (a *AuditRecord) Record(command string, risk *Risk, action string, err error) {
+	if a.redactor != nil {
+		command = a.redactor.Redact(command)
+	}
+	entry := AuditEntry{
+		Time:       time.Now(),
+		User:       currentUsername(),
+		Model:      a.aiClient.model,
+		PromptHash: a.promptHash,
+		Command:    command,
+		Action:     action,
+	}
+	entry.Cwd, _ = os.Getwd()
+	if risk != nil {
+		entry.Risk = risk.Level.String()
+	}
+	var exitError *exec.ExitError
+	switch {
+	case action == AuditExecuted && errors.As(err, &exitError):
+		exitCode := exitError.ExitCode()
+		entry.ExitCode = &exitCode
+	case action == AuditExecuted && err == nil:
+		exitCode := 0
+		entry.ExitCode = &exitCode
+	case err != nil:
+		entry.Action = AuditFailed
+		entry.Error = err.Error()
+	}
+
+	if err := appendAuditEntry(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not write the audit log: %v\n", err)
+	}
+}
+
+func currentUsername() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return firstNonEmpty(os.Getenv("USER"), os.Getenv("USERNAME"))
+}
+
+func appendAuditEntry(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(auditFilePath), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(auditFilePath, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// Concurrent runs of ai would otherwise both append to the same last line, forking the chain.
+	if err := lockFile(file); err != nil {
+		return err
+	}
+	defer unlockFile(file)
+
+	prev, err := lastAuditHash(file)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(auditLine{Entry: data, Prev: prev, Hash: auditHash(prev, data)})
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+func auditHash(prev string, entry []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(prev))
+	hash.Write([]byte("\n"))
+	hash.Write(entry)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// lastAuditHash reads the hash of the last line, reading backwards from the end of the log
+// rather than reading the whole log.
+func lastAuditHash(file *os.File) (string, error) {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return "", err
+	}
+	const chunkSize = 64 * 1024
+	end := info.Size()
+	var tail []byte
+	for offset := end; offset > 0; {
+		size := int64(chunkSize)
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+		chunk := make([]byte, size)
+		if _, err := file.ReadAt(chunk, offset); err != nil && err != io.EOF {
+			return "", err
+		}
+		tail = append(chunk, tail...)
+		// The line ends with a newline; look for the one before it.
+		if bytes.LastIndexByte(bytes.TrimRight(tail, "\n"), '\n') >= 0 {
+			break
+		}
+	}
+	trimmed := bytes.TrimRight(tail, "\n")
+	lastLine := trimmed[bytes.LastIndexByte(trimmed, '\n')+1:]
+	var last auditLine
+	if err := json.Unmarshal(lastLine, &last); err != nil {
+		return "", fmt.Errorf("the last line of %s is damaged: %v", auditFilePath, err)
+	}
+	return last.Hash, nil
+}
+
+// readAuditLog calls visit for each line of the log, with its line number. It stops at the
+// first error that visit returns.
+func readAuditLog(visit func(number int, line auditLine, entry AuditEntry) error) error {
+	file, err := os.Open(auditFilePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	number := 0
+	for scanner.Scan() {
+		number++
+		var line auditLine
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return fmt.Errorf("line %d: %v", number, err)
+		}
+		if err := json.Unmarshal(line.Entry, &entry); err != nil {
+			return fmt.Errorf("line %d: %v", number, err)
+		}
+		if err := visit(number, line, entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func runAuditVerify(args []string, config Config, model string) {
+	flags := newSubcommandFlagSet("audit verify")
+	flags.Parse(args)
+
+	count, last, err := verifyAuditLog()
+	if os.IsNotExist(err) {
+		fmt.Println("The audit log is empty:", auditFilePath)
+		return
+	}
+	if err != nil {
+		color.Red("The audit log %s failed verification: %v", auditFilePath, err)
+		os.Exit(1)
+	}
+	color.Green("The audit log is intact: %d entries", count)
+	// Removing lines from the end keeps the chain valid, so the last hash is worth keeping elsewhere.
+	fmt.Println("Last hash:", last)
+}
+
+// verifyAuditLog checks the hash chain of the log. It returns the number of entries and the
+// hash of the last one.
+func verifyAuditLog() (count int, last string, err error) {
+	err = readAuditLog(func(number int, line auditLine, entry AuditEntry) error {
+		if line.Prev != last {
+			return fmt.Errorf("line %d: does not follow the line before it; lines were removed or reordered", number)
+		}
+		if auditHash(line.Prev, line.Entry) != line.Hash {
+			return fmt.Errorf("line %d: the entry was modified", number)
+		}
+		last = line.Hash
+		count++
+		return nil
+	})
+	return count, last, err
+}
+
+func runAuditShow(args []string, config Config, model string) {
+	flags := newSubcommandFlagSet("audit show")
+	sinceFlag := flags.String("since", "24h", "Show entries since a duration ago (e.g. 2h, 7d) or a date (2006-01-02)")
+	flags.Parse(args)
+
+	since, err := parseSince(*sinceFlag, time.Now())
+	if err != nil {
+		log.Fatalf("Invalid --since: %v", err)
+	}
+	err = readAuditLog(func(number int, line auditLine, entry AuditEntry) error {
+		if entry.Time.Before(since) {
+			return nil
+		}
+		status := entry.Action
+		if entry.ExitCode != nil {
+			status += fmt.Sprintf(" (exit %d)", *entry.ExitCode)
+		}
+		if entry.Risk != "" {
+			status += ", " + entry.Risk + " risk"
+		}
+		if entry.Error != "" {
+			status += ": " + entry.Error
+		}
+		fmt.Printf("%s  %s@%s  %s  %s\n", entry.Time.Local().Format("2006-01-02 15:04:05"), entry.User, entry.Cwd, entry.Model, status)
+		if entry.Command != "" {
+			fmt.Printf("    %s\n", strings.ReplaceAll(entry.Command, "\n", "\n    "))
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatalf("Error reading the audit log: %v", err)
+	}
+}
+
+// parseSince accepts a duration such as 90m or 7d, or a date or timestamp.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		var count int
+		if _, err := fmt.Sscanf(days, "%d", &count); err == nil {
+			return now.AddDate(0, 0, -count), nil
+		}
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(-duration), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a duration nor a date", value)
+}
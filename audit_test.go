@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useTempAuditLog points the audit log at a temporary file for the duration of a test.
+func useTempAuditLog(t *testing.T) {
+	original := auditFilePath
+	auditFilePath = filepath.Join(t.TempDir(), "audit.jsonl")
+	t.Cleanup(func() { auditFilePath = original })
+}
+
+func appendTestEntries(t *testing.T, commands ...string) {
+	for _, command := range commands {
+		if err := appendAuditEntry(AuditEntry{Command: command, Action: AuditTyped}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readAuditLines(t *testing.T) []string {
+	data, err := ioutil.ReadFile(auditFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func writeAuditLines(t *testing.T, lines []string) {
+	if err := ioutil.WriteFile(auditFilePath, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditLogVerifies(t *testing.T) {
+	useTempAuditLog(t)
+	// An entry longer than the chunks that lastAuditHash reads.
+	appendTestEntries(t, "ls", strings.Repeat("x", 200*1024), "git status")
+
+	count, last, err := verifyAuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("count = %d, want 3", count)
+	}
+	var entries []string
+	readAuditLog(func(number int, line auditLine, entry AuditEntry) error {
+		entries = append(entries, entry.Command)
+		if number == 3 && line.Hash != last {
+			t.Errorf("last hash = %s, want %s", last, line.Hash)
+		}
+		return nil
+	})
+	if len(entries) != 3 || entries[2] != "git status" {
+		t.Errorf("entries = %.20q", entries)
+	}
+}
+
+func TestAuditLogDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines []string) []string
+		want   string
+	}{
+		{"modified", func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], "rm -rf build", "ls", 1)
+			return lines
+		}, "line 2: the entry was modified"},
+		{"removed", func(lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		}, "line 2: does not follow"},
+		{"reordered", func(lines []string) []string {
+			lines[0], lines[1] = lines[1], lines[0]
+			return lines
+		}, "line 1: does not follow"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTempAuditLog(t)
+			appendTestEntries(t, "ls", "rm -rf build", "git status")
+			writeAuditLines(t, test.tamper(readAuditLines(t)))
+
+			_, _, err := verifyAuditLog()
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("verifyAuditLog() = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestAuditRecordRedactsCommands(t *testing.T) {
+	useTempAuditLog(t)
+	redactor := newRedactor(Config{})
+	redactor.Redact("export GITHUB_TOKEN=ghp_" + strings.Repeat("a", 36))
+
+	newAuditRecord(&AIClient{model: "model"}, nil, redactor).Record("curl -H 'Authorization: token ghp_"+strings.Repeat("a", 36)+"' api.github.com", nil, AuditTyped, nil)
+	lines := readAuditLines(t)
+	if strings.Contains(lines[0], "ghp_") || !strings.Contains(lines[0], "REDACTED_") {
+		t.Errorf("audit line contains the secret: %s", lines[0])
+	}
+}
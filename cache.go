@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var cacheDir = getCacheDir()
+
+func getCacheDir() string {
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if dir == "" {
+		dir, _ = os.UserCacheDir()
+	}
+	return filepath.Join(dir, "ai")
+}
+
+// cacheEntry is a cached value together with the key it was computed for. A value is only
+// used when the key still matches, e.g. the PATH the tools were looked up in.
+type cacheEntry struct {
+	Key     string          `json:"key"`
+	Created time.Time       `json:"created"`
+	Value   json.RawMessage `json:"value"`
+}
+
+// readCache loads a cached value into value. It returns false when there is no entry, the
+// key doesn't match or the entry is older than ttl.
+func readCache(name, key string, ttl time.Duration, value interface{}) bool {
+	data, err := ioutil.ReadFile(filepath.Join(cacheDir, name+".json"))
+	if err != nil {
+		return false
+	}
+	var entry cacheEntry
+	if json.Unmarshal(data, &entry) != nil {
+		return false
+	}
+	if entry.Key != key || time.Since(entry.Created) > ttl {
+		return false
+	}
+	return json.Unmarshal(entry.Value, value) == nil
+}
+
+// clearCache removes all cached values.
+func clearCache() error {
+	return os.RemoveAll(cacheDir)
+}
+
+// writeCache stores a value. Failures are ignored; the value is computed again next time.
+func writeCache(name, key string, value interface{}) {
+	valueData, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(cacheEntry{Key: key, Created: time.Now(), Value: valueData})
+	if err != nil {
+		return
+	}
+	if os.MkdirAll(cacheDir, 0700) != nil {
+		return
+	}
+	_ = ioutil.WriteFile(filepath.Join(cacheDir, name+".json"), data, 0600)
+}
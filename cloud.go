@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+)
+
+// CloudContext holds the active kubectl and cloud CLI settings. It is read from their
+// config files only; no network service is called.
+type CloudContext struct {
+	Kubernetes *KubernetesContext
+	AWS        *AWSContext
+	GCloud     *GCloudContext
+	Azure      *AzureContext
+}
+
+type KubernetesContext struct {
+	Context   string
+	Cluster   string
+	Namespace string
+}
+
+type AWSContext struct {
+	Profile string
+	Region  string
+}
+
+type GCloudContext struct {
+	Account string
+	Project string
+}
+
+type AzureContext struct {
+	Subscription string
+	User         string
+}
+
+func gatherCloudContext(request ContextRequest) (interface{}, error) {
+	cloud := CloudContext{
+		Kubernetes: readKubernetesContext(),
+		AWS:        readAWSContext(),
+		GCloud:     readGCloudContext(),
+		Azure:      readAzureContext(),
+	}
+	if cloud.Kubernetes == nil && cloud.AWS == nil && cloud.GCloud == nil && cloud.Azure == nil {
+		return nil, nil
+	}
+	return cloud, nil
+}
+
+// cloudBanner lists what is shared with the model, so that it is visible to the user. It shows
+// the same fields as the cloud section of the prompt.
+func cloudBanner(value interface{}) string {
+	cloud := value.(CloudContext)
+	var parts []string
+	if k := cloud.Kubernetes; k != nil {
+		cluster := ""
+		if k.Cluster != "" {
+			cluster = "cluster " + k.Cluster + ", "
+		}
+		parts = append(parts, fmt.Sprintf("kubectl context %s (%snamespace %s)", k.Context, cluster, k.Namespace))
+	}
+	if aws := cloud.AWS; aws != nil {
+		parts = append(parts, fmt.Sprintf("AWS profile %s (region %s)", aws.Profile, firstNonEmpty(aws.Region, "not set")))
+	}
+	if gcloud := cloud.GCloud; gcloud != nil {
+		parts = append(parts, fmt.Sprintf("gcloud account %s (project %s)", gcloud.Account, firstNonEmpty(gcloud.Project, "not set")))
+	}
+	if azure := cloud.Azure; azure != nil {
+		user := ""
+		if azure.User != "" {
+			user = " (user " + azure.User + ")"
+		}
+		parts = append(parts, fmt.Sprintf("Azure subscription %s%s", azure.Subscription, user))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func readKubernetesContext() *KubernetesContext {
+	paths := filepath.SplitList(os.Getenv("KUBECONFIG"))
+	if len(paths) == 0 {
+		paths = []string{filepath.Join(homeDir, ".kube", "config")}
+	}
+
+	type kubeconfig struct {
+		CurrentContext string `yaml:"current-context"`
+		Contexts       []struct {
+			Name    string `yaml:"name"`
+			Context struct {
+				Cluster   string `yaml:"cluster"`
+				Namespace string `yaml:"namespace"`
+			} `yaml:"context"`
+		} `yaml:"contexts"`
+	}
+	// Like kubectl, the first file that sets a value wins.
+	var configs []kubeconfig
+	currentContext := ""
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var config kubeconfig
+		if yaml.Unmarshal(data, &config) != nil {
+			continue
+		}
+		configs = append(configs, config)
+		if currentContext == "" {
+			currentContext = config.CurrentContext
+		}
+	}
+	if currentContext == "" {
+		return nil
+	}
+
+	for _, config := range configs {
+		for _, context := range config.Contexts {
+			if context.Name == currentContext {
+				return &KubernetesContext{
+					Context:   currentContext,
+					Cluster:   context.Context.Cluster,
+					Namespace: firstNonEmpty(context.Context.Namespace, "default"),
+				}
+			}
+		}
+	}
+	return &KubernetesContext{Context: currentContext, Namespace: "default"}
+}
+
+func readAWSContext() *AWSContext {
+	configPath := firstNonEmpty(os.Getenv("AWS_CONFIG_FILE"), filepath.Join(homeDir, ".aws", "config"))
+	profile := firstNonEmpty(os.Getenv("AWS_PROFILE"), os.Getenv("AWS_DEFAULT_PROFILE"))
+	if profile == "" && !fileExists(configPath) {
+		return nil
+	}
+	if profile == "" {
+		profile = "default"
+	}
+
+	config := readINI(configPath)
+	section := "profile " + profile
+	if profile == "default" {
+		section = "default"
+	}
+	region := firstNonEmpty(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), config[section]["region"])
+	return &AWSContext{Profile: profile, Region: region}
+}
+
+func readGCloudContext() *GCloudContext {
+	configDir := os.Getenv("CLOUDSDK_CONFIG")
+	if configDir == "" {
+		if runtime.GOOS == "windows" {
+			configDir = filepath.Join(os.Getenv("APPDATA"), "gcloud")
+		} else {
+			configDir = filepath.Join(homeDir, ".config", "gcloud")
+		}
+	}
+
+	activeConfig, err := ioutil.ReadFile(filepath.Join(configDir, "active_config"))
+	if err != nil {
+		return nil
+	}
+	name := firstNonEmpty(os.Getenv("CLOUDSDK_ACTIVE_CONFIG_NAME"), strings.TrimSpace(string(activeConfig)))
+	core := readINI(filepath.Join(configDir, "configurations", "config_"+name))["core"]
+	account := firstNonEmpty(os.Getenv("CLOUDSDK_CORE_ACCOUNT"), core["account"])
+	if account == "" {
+		return nil
+	}
+	return &GCloudContext{Account: account, Project: firstNonEmpty(os.Getenv("CLOUDSDK_CORE_PROJECT"), core["project"])}
+}
+
+func readAzureContext() *AzureContext {
+	configDir := firstNonEmpty(os.Getenv("AZURE_CONFIG_DIR"), filepath.Join(homeDir, ".azure"))
+	data, err := ioutil.ReadFile(filepath.Join(configDir, "azureProfile.json"))
+	if err != nil {
+		return nil
+	}
+
+	var profile struct {
+		Subscriptions []struct {
+			Name      string `json:"name"`
+			IsDefault bool   `json:"isDefault"`
+			User      struct {
+				Name string `json:"name"`
+			} `json:"user"`
+		} `json:"subscriptions"`
+	}
+	// The az CLI writes the file with a byte order mark.
+	if json.Unmarshal(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), &profile) != nil {
+		return nil
+	}
+	for _, subscription := range profile.Subscriptions {
+		if subscription.IsDefault {
+			return &AzureContext{Subscription: subscription.Name, User: subscription.User.Name}
+		}
+	}
+	return nil
+}
+
+// readINI parses the sections and keys of an INI file such as ~/.aws/config.
+func readINI(path string) map[string]map[string]string {
+	sections := map[string]map[string]string{}
+	file, err := os.Open(path)
+	if err != nil {
+		return sections
+	}
+	defer file.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		if sections[section] == nil {
+			sections[section] = map[string]string{}
+		}
+		sections[section][strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return sections
+}
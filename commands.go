@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Subcommand is invoked as `ai <name...> [flags]`. Name has one or two words, e.g. "prompts test".
+type Subcommand struct {
+	Name        string
+	Description string
+	Run         func(args []string, config Config, model string)
+	// FlagsOnly subcommands take only boolean flags, so that other words after the name, as in
+	// `ai undo the last commit`, make the arguments a natural language request instead.
+	FlagsOnly bool
+}
+
+var subcommands = []Subcommand{
+	{Name: "prompts test", Description: "Run the prompt regression suite", Run: runPromptsTest},
+	{Name: "audit verify", Description: "Check that the audit log wasn't modified", Run: runAuditVerify, FlagsOnly: true},
+	{Name: "audit show", Description: "Show the audit log, e.g. --since 7d", Run: runAuditShow},
+	{Name: "undo", Description: "Restore the files changed by the last executed command", Run: runUndo, FlagsOnly: true},
+	{Name: "cache clear", Description: "Forget the cached shell, system and tool facts", Run: runCacheClear, FlagsOnly: true},
+}
+
+// runSubcommand runs the subcommand named by the first arguments. It returns false when the
+// arguments are a natural language request instead.
+func runSubcommand(args []string, config Config, model string) bool {
+	for _, subcommand := range subcommands {
+		words := strings.Fields(subcommand.Name)
+		if len(args) < len(words) || strings.Join(args[:len(words)], " ") != subcommand.Name {
+			continue
+		}
+		if subcommand.FlagsOnly && hasPositionalArgs(args[len(words):]) {
+			continue
+		}
+		subcommand.Run(args[len(words):], config, model)
+		return true
+	}
+	return false
+}
+
+func hasPositionalArgs(args []string) bool {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			return true
+		}
+	}
+	return false
+}
+
+func printSubcommands() {
+	fmt.Println("Subcommands:")
+	for _, subcommand := range subcommands {
+		fmt.Printf("  %-16s %s\n", subcommand.Name, subcommand.Description)
+	}
+}
+
+func runCacheClear(args []string, config Config, model string) {
+	flags := newSubcommandFlagSet("cache clear")
+	flags.Parse(args)
+
+	if err := clearCache(); err != nil {
+		log.Fatalf("Error clearing cache: %v", err)
+	}
+	fmt.Println("Cleared", cacheDir)
+}
+
+// newSubcommandFlagSet creates a flag set that exits on errors, like the global flags.
+func newSubcommandFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet("ai "+name, flag.ExitOnError)
+	flags.SetOutput(os.Stdout)
+	return flags
+}
@@ -1,86 +1,238 @@
 package main
 
 import (
-	"fmt"
 	"github.com/go-yaml/yaml"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 )
 
+const (
+	ProviderOpenAI           = "openai"
+	ProviderAzure            = "azure"
+	ProviderOpenAICompatible = "openai-compatible"
+)
+
+var providerNames = []string{ProviderOpenAI, ProviderAzure, ProviderOpenAICompatible}
+
+type ProviderConfig struct {
+	BaseURL string `yaml:"base_url,omitempty"`
+	// APIKey is a secret reference, e.g. "env:OPENAI_API_KEY", "command:pass show openai"
+	// or "encrypted:openai". See resolveSecret.
+	APIKey string `yaml:"api_key,omitempty"`
+	// Keys is a pool of labeled keys. They are tried in order, rotating on auth and quota errors.
+	Keys []APIKeyConfig `yaml:"keys,omitempty"`
+}
+
+type APIKeyConfig struct {
+	Label  string `yaml:"label"`
+	APIKey string `yaml:"api_key"`
+	// MonthlyCap is the maximum estimated spend in USD per calendar month; zero means no cap.
+	MonthlyCap float64 `yaml:"monthly_cap,omitempty"`
+}
+
+// ModelPrice is the price in USD per million tokens.
+type ModelPrice struct {
+	Input  float64 `yaml:"input"`
+	Output float64 `yaml:"output"`
+}
+
+// RedactPattern is a custom regular expression for secrets. When it has a group named "secret",
+// only that group is redacted.
+type RedactPattern struct {
+	Name  string `yaml:"name"`
+	Regex string `yaml:"regex"`
+}
+
+// APIKeys returns the single api_key, labeled "default", followed by the keys of the pool.
+func (p ProviderConfig) APIKeys() []APIKeyConfig {
+	var keys []APIKeyConfig
+	if p.APIKey != "" {
+		keys = append(keys, APIKeyConfig{Label: "default", APIKey: p.APIKey})
+	}
+	return append(keys, p.Keys...)
+}
+
 type Config struct {
-	OpenAI struct {
-		APIKey string `yaml:"api_key"`
-	} `yaml:"openai"`
+	Provider     string                     `yaml:"provider,omitempty"`
+	DefaultModel string                     `yaml:"default_model,omitempty"`
+	Providers    map[string]*ProviderConfig `yaml:"providers,omitempty"`
+	// Context enables or disables context providers by name, e.g. {git: false}.
+	Context map[string]bool `yaml:"context,omitempty"`
+	// HistoryLines is the number of recent commands shared when the history provider is enabled.
+	HistoryLines int `yaml:"history_lines,omitempty"`
+	// FilesDepth and FilesEntries limit the listing shared when the files provider is enabled.
+	FilesDepth   int `yaml:"files_depth,omitempty"`
+	FilesEntries int `yaml:"files_entries,omitempty"`
+	// Plugins lists context plugins in addition to the ai-context-* executables on PATH.
+	Plugins []string `yaml:"plugins,omitempty"`
+	// Redaction replaces secrets in outgoing messages with placeholders. It is enabled by default.
+	Redaction *bool `yaml:"redaction,omitempty"`
+	// RedactPatterns are redacted in addition to the built-in secret patterns.
+	RedactPatterns []RedactPattern `yaml:"redact_patterns,omitempty"`
+	// ConfirmRisk is the risk level from which --execute asks for confirmation: low, medium or
+	// high (the default).
+	ConfirmRisk string `yaml:"confirm_risk,omitempty"`
+	// UndoKeep and UndoDays limit the snapshots kept for ai undo, 10 and 7 by default.
+	UndoKeep int `yaml:"undo_keep,omitempty"`
+	UndoDays int `yaml:"undo_days,omitempty"`
+	// ContextTimeout is the number of seconds a context provider may take, 2 by default.
+	ContextTimeout float64 `yaml:"context_timeout,omitempty"`
+	// PluginTimeout is the number of seconds a context plugin may take, 2 by default.
+	PluginTimeout float64 `yaml:"plugin_timeout,omitempty"`
+	// PromptVariables are available in prompts.yaml as {{.vars.<name>}}.
+	PromptVariables map[string]string `yaml:"prompt_variables,omitempty"`
+	// Prices override the built-in model prices used to estimate spend for monthly caps.
+	Prices map[string]ModelPrice `yaml:"prices,omitempty"`
+
+	// OpenAI is the configuration format used before multiple providers were supported.
+	OpenAI *ProviderConfig `yaml:"openai,omitempty"`
+}
+
+// ActiveProvider returns the name and configuration of the provider to use.
+func (c Config) ActiveProvider() (string, ProviderConfig) {
+	name := c.Provider
+	if name == "" {
+		name = ProviderOpenAI
+	}
+	if provider, ok := c.Providers[name]; ok && provider != nil {
+		return name, *provider
+	}
+	if name == ProviderOpenAI && c.OpenAI != nil {
+		return name, *c.OpenAI
+	}
+	return name, ProviderConfig{}
+}
+
+// ProjectConfig is read from .ai.yaml in the working directory or one of its parents.
+// It lets a project opt out of context providers. As any cloned repository can contain one, it
+// can't opt into them.
+type ProjectConfig struct {
+	Context map[string]bool `yaml:"context,omitempty"`
 }
 
+const projectConfigFileName = ".ai.yaml"
+
 var homeDir, _ = os.UserHomeDir()
 var configFilePath = filepath.Join(homeDir, "ai.yaml")
 
-func getAPIKey() string {
-	apiKey := readAPIKey()
-	if apiKey == "" {
-		apiKey = initApiKey()
+var stateDir = getStateDir()
+
+func getStateDir() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		if runtime.GOOS == "windows" {
+			dir, _ = os.UserConfigDir()
+		} else {
+			dir = filepath.Join(homeDir, ".local", "state")
+		}
+	}
+	return filepath.Join(dir, "ai")
+}
+
+// getAPIKeys returns the key references to use, running the setup wizard when there are none.
+func getAPIKeys() []APIKeyConfig {
+	keys := readAPIKeys()
+	if len(keys) == 0 {
+		runInitWizard()
+		keys = readAPIKeys()
 	}
-	return apiKey
+	return keys
 }
 
-func readAPIKey() string {
+func readAPIKeys() []APIKeyConfig {
 	// Check if the API key is set in the environment variable
-	envAPIKey := os.Getenv("OPENAI_API_KEY")
-	if envAPIKey != "" {
-		return envAPIKey
+	if os.Getenv("OPENAI_API_KEY") != "" {
+		return []APIKeyConfig{{Label: "OPENAI_API_KEY", APIKey: secretRefEnv + "OPENAI_API_KEY"}}
 	}
 
+	_, provider := readConfig().ActiveProvider()
+	return provider.APIKeys()
+}
+
+func readConfig() Config {
+	var config Config
 	configFile, err := ioutil.ReadFile(configFilePath)
 	if err != nil {
-		return ""
+		return config
 	}
 
-	var config Config
 	err = yaml.Unmarshal(configFile, &config)
 	if err != nil {
 		log.Fatalf("Error unmarshalling config file: %v", err)
 	}
+	return config
+}
 
-	return config.OpenAI.APIKey
+// readProjectConfig reads the nearest .ai.yaml, looking from dir up to the root.
+func readProjectConfig(dir string) ProjectConfig {
+	var config ProjectConfig
+	for {
+		data, err := ioutil.ReadFile(filepath.Join(dir, projectConfigFileName))
+		if err == nil {
+			err = yaml.Unmarshal(data, &config)
+			if err != nil {
+				log.Fatalf("Error unmarshalling %s: %v", filepath.Join(dir, projectConfigFileName), err)
+			}
+			return config
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return config
+		}
+		dir = parent
+	}
 }
 
-func askAPIKey() string {
-	var apiKey string
-	fmt.Print("Enter your OpenAI API Key (configuration will be updated): ")
-	fmt.Scanln(&apiKey)
-	return apiKey
+// RedactionEnabled tells whether redaction is enabled in the config or required by the policy.
+func (c Config) RedactionEnabled() bool {
+	return c.Redaction == nil || *c.Redaction || loadPolicy().RedactionRequired()
 }
 
-func writeAPIKey(apiKey string) {
-	config := Config{
-		OpenAI: struct {
-			APIKey string `yaml:"api_key"`
-		}{
-			APIKey: apiKey,
-		},
+// contextEnabled tells whether a context provider may run. The project config can only disable
+// providers that the user's config enables.
+func contextEnabled(name string, defaultEnabled bool, workingDirectory string) bool {
+	if loadPolicy().ContextDenied(name) {
+		return false
+	}
+	if enabled, ok := readProjectConfig(workingDirectory).Context[name]; ok && !enabled {
+		return false
+	}
+	if enabled, ok := readConfig().Context[name]; ok {
+		return enabled
 	}
+	return defaultEnabled
+}
 
+func writeConfig(config Config) {
 	configData, err := yaml.Marshal(config)
 	if err != nil {
 		log.Fatalf("Error marshalling config data: %v", err)
 	}
 
-	err = ioutil.WriteFile(configFilePath, configData, 0644)
+	// The config only holds secret references, but keep it private regardless.
+	err = ioutil.WriteFile(configFilePath, configData, 0600)
 	if err != nil {
 		log.Fatalf("Error writing config file: %v", err)
 	}
-
-	fmt.Printf("API key added to your %s\n", configFilePath)
+	err = os.Chmod(configFilePath, 0600)
+	if err != nil {
+		log.Fatalf("Error setting config file permissions: %v", err)
+	}
 }
 
-func initApiKey() string {
-	fmt.Printf("Please provide your OpenAI API.\n"+
-		"- Through an environment variable: OPENAI_API_KEY\n"+
-		"- Through a configuration file:    %s\n", configFilePath)
-	var apiKey = askAPIKey()
-	writeAPIKey(apiKey)
-	return apiKey
+// storeAPIKey stores the API key in the encrypted secret store and returns a reference to it.
+// Values that already are secret references are returned as-is.
+func storeAPIKey(provider, apiKey string) string {
+	if isSecretReference(apiKey) {
+		return apiKey
+	}
+	store := newEncryptedFileStore(secretsFilePath)
+	err := store.Set(provider, apiKey)
+	if err != nil {
+		log.Fatalf("Error storing API key: %v", err)
+	}
+	return secretRefEncrypted + provider
 }
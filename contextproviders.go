@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// ContextRequest describes the request that context is gathered for.
+type ContextRequest struct {
+	UserInput        string
+	Shell            string
+	WorkingDirectory string
+}
+
+// ContextProvider gathers information about the user's environment. The result is available
+// in the prompt templates under the provider's name.
+type ContextProvider struct {
+	Name   string
+	Gather func(request ContextRequest) (interface{}, error)
+	// OptIn providers only run when enabled in the config. Others run unless disabled.
+	OptIn bool
+	// Banner, when set, describes the gathered value to the user before it is sent.
+	Banner func(value interface{}) string
+	// Timeout overrides the deadline of the provider.
+	Timeout time.Duration
+}
+
+const defaultContextTimeout = 2 * time.Second
+
+// debugContext reports the timing of each provider, as set by --debug.
+var debugContext = false
+
+var contextProviders = []ContextProvider{
+	{Name: "shell_version", Gather: gatherShellVersion},
+	{Name: "system_info", Gather: gatherSystemInfo},
+	{Name: "package_managers", Gather: gatherPackageManagers},
+	{Name: "sudo", Gather: gatherSudoAccess, Timeout: sudoTimeout + time.Second},
+	{Name: "tools", Gather: gatherTools, Timeout: toolsTimeout + time.Second},
+	{Name: "git", Gather: gatherGitContext},
+	{Name: "projects", Gather: gatherProjects},
+	{Name: "cloud", Gather: gatherCloudContext, Banner: cloudBanner},
+	{Name: "aliases", Gather: gatherAliases, Timeout: aliasesTimeout + time.Second},
+	{Name: "history", Gather: gatherHistory, OptIn: true},
+	{Name: "files", Gather: gatherDirectoryListing, OptIn: true},
+	// Plugins are bounded by plugin_timeout themselves.
+	{Name: "plugins", Gather: gatherPlugins, Timeout: time.Minute},
+}
+
+// gatherContext runs the enabled context providers concurrently and adds their results to the
+// template data. A provider that is disabled, fails or misses its deadline leaves its variable
+// empty.
+func gatherContext(request ContextRequest, data map[string]interface{}) {
+	defaultTimeout := defaultContextTimeout
+	if timeout := readConfig().ContextTimeout; timeout > 0 {
+		defaultTimeout = time.Duration(timeout * float64(time.Second))
+	}
+
+	var enabled []ContextProvider
+	for _, provider := range contextProviders {
+		data[provider.Name] = nil
+		if contextEnabled(provider.Name, !provider.OptIn, request.WorkingDirectory) {
+			enabled = append(enabled, provider)
+		}
+	}
+
+	results := make([]contextResult, len(enabled))
+	var wg sync.WaitGroup
+	for i, provider := range enabled {
+		timeout := provider.Timeout
+		if timeout == 0 {
+			timeout = defaultTimeout
+		}
+		wg.Add(1)
+		go func(i int, provider ContextProvider) {
+			defer wg.Done()
+			results[i] = runContextProvider(provider, request, timeout)
+		}(i, provider)
+	}
+	wg.Wait()
+
+	for i, provider := range enabled {
+		result := results[i]
+		switch {
+		case result.TimedOut:
+			if debugContext {
+				fmt.Printf("Debug: context provider %s dropped after %s\n", provider.Name, result.Elapsed)
+			}
+			continue
+		case result.Err != nil:
+			fmt.Fprintf(os.Stderr, "Warning: context provider %s failed: %v\n", provider.Name, result.Err)
+			continue
+		}
+		if debugContext {
+			fmt.Printf("Debug: context provider %s took %s\n", provider.Name, result.Elapsed.Round(time.Millisecond))
+		}
+		data[provider.Name] = result.Value
+		if provider.Banner != nil && result.Value != nil {
+			color.New(color.Faint).Fprintf(os.Stderr, "Sharing %s: %s\n", provider.Name, provider.Banner(result.Value))
+		}
+	}
+}
+
+type contextResult struct {
+	Value    interface{}
+	Err      error
+	Elapsed  time.Duration
+	TimedOut bool
+}
+
+// runContextProvider waits for the provider until the timeout. A provider that times out keeps
+// running in the background, but its result is ignored.
+func runContextProvider(provider ContextProvider, request ContextRequest, timeout time.Duration) contextResult {
+	start := time.Now()
+	done := make(chan contextResult, 1)
+	go func() {
+		value, err := provider.Gather(request)
+		done <- contextResult{Value: value, Err: err}
+	}()
+
+	select {
+	case result := <-done:
+		result.Elapsed = time.Since(start)
+		return result
+	case <-time.After(timeout):
+		return contextResult{Elapsed: timeout, TimedOut: true}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DirectoryListing summarizes the working directory: the first entries up to a depth, and the
+// number of files and total size per extension.
+type DirectoryListing struct {
+	Entries    []DirectoryEntry
+	Extensions []ExtensionCount
+	TotalFiles int
+	TotalDirs  int
+	// Truncated is set when entries were left out because of the depth or entry limits.
+	Truncated bool
+}
+
+type DirectoryEntry struct {
+	Path  string
+	IsDir bool
+	Size  string
+}
+
+type ExtensionCount struct {
+	Extension string
+	Count     int
+	Size      string
+}
+
+const (
+	defaultListingDepth   = 2
+	defaultListingEntries = 100
+	// Counts by extension stop after this many files, so that huge trees stay fast.
+	maxListingWalk = 20000
+)
+
+// gatherDirectoryListing is opt-in: it sends file names from the working directory.
+func gatherDirectoryListing(request ContextRequest) (interface{}, error) {
+	config := readConfig()
+	maxDepth := config.FilesDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultListingDepth
+	}
+	maxEntries := config.FilesEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultListingEntries
+	}
+
+	ignore := newGitIgnore(request.WorkingDirectory)
+	listing := &DirectoryListing{}
+	extensions := map[string]*ExtensionCount{}
+	sizes := map[string]int64{}
+	walked := 0
+
+	err := filepath.WalkDir(request.WorkingDirectory, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		relativePath, _ := filepath.Rel(request.WorkingDirectory, filePath)
+		if relativePath == "." {
+			return nil
+		}
+		relativePath = filepath.ToSlash(relativePath)
+		if entry.Name() == ".git" || ignore.Ignored(relativePath, entry.IsDir()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			ignore.addFile(filepath.Join(filePath, ".gitignore"), "", relativePath)
+		}
+
+		walked++
+		if walked > maxListingWalk {
+			listing.Truncated = true
+			return filepath.SkipAll
+		}
+		depth := strings.Count(relativePath, "/") + 1
+
+		var size int64
+		if entry.IsDir() {
+			listing.TotalDirs++
+		} else {
+			listing.TotalFiles++
+			if info, err := entry.Info(); err == nil {
+				size = info.Size()
+			}
+			extension := strings.ToLower(path.Ext(entry.Name()))
+			if extension == "" {
+				extension = "(none)"
+			}
+			if extensions[extension] == nil {
+				extensions[extension] = &ExtensionCount{Extension: extension}
+			}
+			extensions[extension].Count++
+			sizes[extension] += size
+		}
+
+		// Deeper files still count towards the totals, but aren't listed.
+		if depth > maxDepth || len(listing.Entries) >= maxEntries {
+			listing.Truncated = true
+			return nil
+		}
+		directoryEntry := DirectoryEntry{Path: relativePath, IsDir: entry.IsDir()}
+		if !entry.IsDir() {
+			directoryEntry.Size = formatSize(size)
+		}
+		listing.Entries = append(listing.Entries, directoryEntry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for extension, count := range extensions {
+		count.Size = formatSize(sizes[extension])
+		listing.Extensions = append(listing.Extensions, *count)
+	}
+	sort.Slice(listing.Extensions, func(i, j int) bool {
+		if listing.Extensions[i].Count != listing.Extensions[j].Count {
+			return listing.Extensions[i].Count > listing.Extensions[j].Count
+		}
+		return listing.Extensions[i].Extension < listing.Extensions[j].Extension
+	})
+	return listing, nil
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// gitIgnore implements the common subset of .gitignore patterns: globs, negation with "!",
+// directory-only patterns ending in "/", anchored patterns containing "/", and "**".
+type gitIgnore struct {
+	rules []gitIgnoreRule
+}
+
+type gitIgnoreRule struct {
+	// prefix is the listed directory relative to the directory of the .gitignore file, for
+	// .gitignore files in its parents.
+	prefix string
+	// base is the directory of the .gitignore file relative to the listed directory, for
+	// .gitignore files in its subdirectories. Their rules only apply below it.
+	base     string
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// newGitIgnore reads .gitignore in dir and in its parents up to the repository root. The
+// .gitignore files of subdirectories are added with addFile while walking.
+func newGitIgnore(dir string) *gitIgnore {
+	ignore := &gitIgnore{}
+	var files []string
+	for current := dir; ; current = filepath.Dir(current) {
+		files = append([]string{current}, files...)
+		if fileExists(filepath.Join(current, ".git")) || filepath.Dir(current) == current {
+			break
+		}
+	}
+	for _, fileDir := range files {
+		prefix, _ := filepath.Rel(fileDir, dir)
+		ignore.addFile(filepath.Join(fileDir, ".gitignore"), filepath.ToSlash(prefix), "")
+	}
+	return ignore
+}
+
+func (g *gitIgnore) addFile(file, prefix, base string) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		g.add(prefix, base, line)
+	}
+}
+
+func (g *gitIgnore) add(prefix, base, line string) {
+	line = strings.TrimRight(line, " \r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+	rule := gitIgnoreRule{prefix: prefix, base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	rule.pattern = line
+	g.rules = append(g.rules, rule)
+}
+
+// Ignored tells whether a slash-separated path relative to the listed directory is ignored.
+// The last matching rule wins.
+func (g *gitIgnore) Ignored(relativePath string, isDir bool) bool {
+	ignored := false
+	for _, rule := range g.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		target := relativePath
+		if rule.base != "" {
+			if !strings.HasPrefix(relativePath, rule.base+"/") {
+				continue
+			}
+			target = strings.TrimPrefix(relativePath, rule.base+"/")
+		}
+		// Rules match paths relative to the directory of their .gitignore file.
+		if rule.matches(path.Join(rule.prefix, target)) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func (r gitIgnoreRule) matches(target string) bool {
+	if r.anchored {
+		return matchGlobPath(r.pattern, target)
+	}
+	// Unanchored patterns match the name at any level.
+	return matchGlobPath(r.pattern, path.Base(target))
+}
+
+// matchGlobPath matches a slash-separated path against a pattern in which "**" matches any
+// number of directories.
+func matchGlobPath(pattern, target string) bool {
+	patternParts := strings.Split(pattern, "/")
+	targetParts := strings.Split(target, "/")
+	var match func(p, t int) bool
+	match = func(p, t int) bool {
+		if p == len(patternParts) {
+			return t == len(targetParts)
+		}
+		if patternParts[p] == "**" {
+			for skip := t; skip <= len(targetParts); skip++ {
+				if match(p+1, skip) {
+					return true
+				}
+			}
+			return false
+		}
+		if t == len(targetParts) {
+			return false
+		}
+		ok, _ := path.Match(patternParts[p], targetParts[t])
+		return ok && match(p+1, t+1)
+	}
+	return match(0, 0)
+}
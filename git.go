@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// GitContext summarizes the repository of the working directory. It only contains names
+// and counts; file contents and remote URLs are never included.
+type GitContext struct {
+	Branch     string
+	Upstream   string
+	Ahead      int
+	Behind     int
+	DirtyFiles int
+	// RemoteHost is the hosting service of the origin remote, e.g. "GitHub" or "GitLab".
+	RemoteHost string
+	Tags       []string
+}
+
+const recentTagsCount = 5
+
+// gatherGitContext returns nil when the working directory isn't inside a git repository.
+func gatherGitContext(request ContextRequest) (interface{}, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, nil
+	}
+	if git(request.WorkingDirectory, "rev-parse", "--is-inside-work-tree") != "true" {
+		return nil, nil
+	}
+
+	gitContext := &GitContext{}
+	gitContext.Branch = git(request.WorkingDirectory, "symbolic-ref", "--short", "-q", "HEAD")
+	if gitContext.Branch == "" {
+		gitContext.Branch = "detached HEAD at " + git(request.WorkingDirectory, "rev-parse", "--short", "HEAD")
+	}
+
+	gitContext.Upstream = git(request.WorkingDirectory, "rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{u}")
+	if gitContext.Upstream != "" {
+		counts := strings.Fields(git(request.WorkingDirectory, "rev-list", "--left-right", "--count", "HEAD...@{u}"))
+		if len(counts) == 2 {
+			gitContext.Ahead, _ = strconv.Atoi(counts[0])
+			gitContext.Behind, _ = strconv.Atoi(counts[1])
+		}
+	}
+
+	status := git(request.WorkingDirectory, "status", "--porcelain")
+	if status != "" {
+		gitContext.DirtyFiles = len(strings.Split(status, "\n"))
+	}
+
+	remote := git(request.WorkingDirectory, "remote", "get-url", "origin")
+	if remote == "" {
+		if remotes := strings.Fields(git(request.WorkingDirectory, "remote")); len(remotes) > 0 {
+			remote = git(request.WorkingDirectory, "remote", "get-url", remotes[0])
+		}
+	}
+	gitContext.RemoteHost = remoteHost(remote)
+
+	tags := git(request.WorkingDirectory, "tag", "--sort=-creatordate")
+	if tags != "" {
+		gitContext.Tags = strings.Split(tags, "\n")
+		if len(gitContext.Tags) > recentTagsCount {
+			gitContext.Tags = gitContext.Tags[:recentTagsCount]
+		}
+	}
+	return gitContext, nil
+}
+
+// remoteHost names the hosting service of a remote URL, such as git@github.com:org/repo.git.
+func remoteHost(remote string) string {
+	if remote == "" {
+		return ""
+	}
+	var host string
+	if parsed, err := url.Parse(remote); err == nil && parsed.Host != "" {
+		host = parsed.Hostname()
+	} else if at := strings.Index(remote, "@"); at >= 0 {
+		// scp-like syntax: user@host:path
+		host = strings.SplitN(remote[at+1:], ":", 2)[0]
+	} else {
+		return ""
+	}
+
+	lowerHost := strings.ToLower(host)
+	switch {
+	case strings.Contains(lowerHost, "github"):
+		return "GitHub (" + host + ")"
+	case strings.Contains(lowerHost, "gitlab"):
+		return "GitLab (" + host + ")"
+	case strings.Contains(lowerHost, "bitbucket"):
+		return "Bitbucket (" + host + ")"
+	case strings.Contains(lowerHost, "dev.azure.com"), strings.Contains(lowerHost, "visualstudio.com"):
+		return "Azure DevOps (" + host + ")"
+	}
+	return host
+}
+
+// git runs a git command in dir and returns its trimmed output, or an empty string on failure.
+func git(dir string, args ...string) string {
+	output, err := exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
@@ -9,6 +9,7 @@ require (
 	github.com/sashabaranov/go-openai v1.29.2
 	github.com/shirou/gopsutil v3.21.10+incompatible
 	golang.org/x/crypto v0.7.0
+	golang.org/x/sys v0.7.0
 )
 
 require (
@@ -19,7 +20,6 @@ require (
 	github.com/stretchr/testify v1.8.1 // indirect
 	github.com/tklauser/go-sysconf v0.3.11 // indirect
 	github.com/tklauser/numcpus v0.6.0 // indirect
-	golang.org/x/sys v0.7.0 // indirect
 	golang.org/x/term v0.7.0 // indirect
 	gopkg.in/check.v1 v0.0.0-20161208181325-20d25e280405 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHistoryLines = 10
+	// historyTailSize is how much of the end of a history file is read.
+	historyTailSize = 256 * 1024
+)
+
+var (
+	bashTimestampRegex = regexp.MustCompile(`^#\d+$`)
+	zshExtendedRegex   = regexp.MustCompile(`^: \d+:\d+;`)
+	historyNumberRegex = regexp.MustCompile(`^\s*\d+\*?\s+`)
+)
+
+// gatherHistory returns the user's last commands, with secrets redacted. It is opt-in.
+func gatherHistory(request ContextRequest) (interface{}, error) {
+	lines := readConfig().HistoryLines
+	if lines <= 0 {
+		lines = defaultHistoryLines
+	}
+
+	// Bash and zsh only write the history file when the shell exits, so the commands of the
+	// current session come from the shell integration, when it passes them.
+	commands, ok := readShellIntegrationHistory()
+	if !ok {
+		switch request.Shell {
+		case "bash":
+			commands = readBashHistory()
+		case "zsh":
+			commands = readZshHistory()
+		case "fish":
+			commands = readFishHistory()
+		default:
+			return nil, nil
+		}
+	}
+
+	var recent []string
+	for i := len(commands) - 1; i >= 0 && len(recent) < lines; i-- {
+		command := strings.TrimSpace(commands[i])
+		if command == "" || command == "ai" || strings.HasPrefix(command, "ai ") {
+			continue
+		}
+		recent = append([]string{redactText(command)}, recent...)
+	}
+	if len(recent) == 0 {
+		return nil, nil
+	}
+	return recent, nil
+}
+
+// readShellIntegrationHistory reads the recent commands passed by a shell wrapper function
+// through AI_SHELL_HISTORY, as listed by history or fc -ln, oldest first.
+func readShellIntegrationHistory() ([]string, bool) {
+	history, ok := os.LookupEnv("AI_SHELL_HISTORY")
+	if !ok {
+		return nil, false
+	}
+	var commands []string
+	for _, line := range strings.Split(history, "\n") {
+		commands = append(commands, historyNumberRegex.ReplaceAllString(line, ""))
+	}
+	return commands, true
+}
+
+// readHistoryTail reads the end of a history file, without the partial line it starts with.
+func readHistoryTail(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size() - historyTailSize
+	if offset <= 0 {
+		return ioutil.ReadAll(file)
+	}
+	data := make([]byte, historyTailSize)
+	if _, err := file.ReadAt(data, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if newline := bytes.IndexByte(data, '\n'); newline >= 0 {
+		data = data[newline+1:]
+	}
+	return data, nil
+}
+
+// readBashHistory reads $HISTFILE when the shell exports it, or ~/.bash_history.
+func readBashHistory() []string {
+	data, err := readHistoryTail(firstNonEmpty(os.Getenv("HISTFILE"), filepath.Join(homeDir, ".bash_history")))
+	if err != nil {
+		return nil
+	}
+	var commands []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if !bashTimestampRegex.MatchString(line) {
+			commands = append(commands, line)
+		}
+	}
+	return commands
+}
+
+// zshHistoryFiles are the usual locations of the zsh history, which zsh itself has no default
+// for. $HISTFILE is not used: zsh doesn't export it, so one in the environment is usually bash's.
+var zshHistoryFiles = []string{".zsh_history", ".histfile", ".zhistory"}
+
+// readZshHistory reads both the plain and the extended format (": <time>:<duration>;command").
+// Multi-line commands continue with a trailing backslash.
+func readZshHistory() []string {
+	dir := firstNonEmpty(os.Getenv("ZDOTDIR"), homeDir)
+	var newest string
+	var newestTime time.Time
+	for _, name := range zshHistoryFiles {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil && info.ModTime().After(newestTime) {
+			newest, newestTime = filepath.Join(dir, name), info.ModTime()
+		}
+	}
+	if newest == "" {
+		return nil
+	}
+	data, err := readHistoryTail(newest)
+	if err != nil {
+		return nil
+	}
+
+	var commands []string
+	var current strings.Builder
+	for _, line := range strings.Split(string(unmetafyZsh(data)), "\n") {
+		if current.Len() == 0 {
+			line = zshExtendedRegex.ReplaceAllString(line, "")
+		}
+		if strings.HasSuffix(line, `\`) {
+			current.WriteString(strings.TrimSuffix(line, `\`) + "\n")
+			continue
+		}
+		current.WriteString(line)
+		commands = append(commands, current.String())
+		current.Reset()
+	}
+	return commands
+}
+
+// unmetafyZsh decodes zsh's history encoding, in which some bytes are written as 0x83
+// followed by the byte xor 32.
+func unmetafyZsh(data []byte) []byte {
+	if bytes.IndexByte(data, 0x83) < 0 {
+		return data
+	}
+	decoded := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] == 0x83 && i+1 < len(data) {
+			i++
+			decoded = append(decoded, data[i]^32)
+		} else {
+			decoded = append(decoded, data[i])
+		}
+	}
+	return decoded
+}
+
+// readFishHistory reads the YAML-like fish history, in which each entry starts with "- cmd: ".
+func readFishHistory() []string {
+	dataDir := firstNonEmpty(os.Getenv("XDG_DATA_HOME"), filepath.Join(homeDir, ".local", "share"))
+	data, err := readHistoryTail(filepath.Join(dataDir, "fish", "fish_history"))
+	if err != nil {
+		return nil
+	}
+	unescape := strings.NewReplacer(`\\`, `\`, `\n`, "\n")
+
+	var commands []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "- cmd: ") {
+			commands = append(commands, unescape.Replace(strings.TrimPrefix(line, "- cmd: ")))
+		}
+	}
+	return commands
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+)
+
+// InitOptions are the answers of the setup wizard. Fields set through flags are not asked for.
+type InitOptions struct {
+	NonInteractive bool
+	Provider       string
+	BaseURL        string
+	APIKey         string
+	APIKeyStdin    bool
+	DefaultModel   string
+	SkipValidation bool
+}
+
+type ModelLister interface {
+	GetAvailableModels() ([]string, error)
+}
+
+// newModelLister creates the client used to validate the API key. It is a variable so that
+// the validation call can be replaced without network access.
+var newModelLister = func(provider, baseURL, apiKey string) ModelLister {
+	return newSingleKeyAIClient(provider, baseURL, apiKey, "")
+}
+
+var defaultBaseURLs = map[string]string{
+	ProviderOpenAI: "https://api.openai.com/v1",
+}
+
+// preferredModels are chosen as the default model, in this order, when the provider offers them.
+var preferredModels = []string{"gpt-4o", "gpt-4-turbo", "gpt-4", "gpt-4-0613", "gpt-3.5-turbo"}
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
+func runInitWizard() string {
+	return runInit(InitOptions{})
+}
+
+// runInit configures the provider, validates the API key and writes the config file.
+// It returns the API key.
+func runInit(options InitOptions) string {
+	apiKey, err := initProvider(options)
+	if err != nil {
+		log.Fatalf("Initialization failed: %v", err)
+	}
+	return apiKey
+}
+
+func initProvider(options InitOptions) (string, error) {
+	interactive := !options.NonInteractive
+	if interactive {
+		fmt.Printf("Setting up AI. The configuration will be written to %s.\n"+
+			"The OPENAI_API_KEY environment variable, when set, always takes precedence.\n\n", configFilePath)
+	}
+
+	provider := options.Provider
+	if provider == "" && interactive {
+		provider = askChoice("Provider", providerNames, ProviderOpenAI)
+	}
+	if provider == "" {
+		provider = ProviderOpenAI
+	}
+	if !contains(providerNames, provider) {
+		return "", fmt.Errorf("unknown provider %q, expected one of: %s", provider, strings.Join(providerNames, ", "))
+	}
+
+	baseURL := options.BaseURL
+	if baseURL == "" && interactive {
+		baseURL = askLine("Base URL", defaultBaseURLs[provider])
+	}
+	if baseURL == "" {
+		baseURL = defaultBaseURLs[provider]
+	}
+	if baseURL == "" {
+		return "", fmt.Errorf("provider %s requires a base URL", provider)
+	}
+	// Validating the key already sends it to the base URL.
+	if violation := loadPolicy().CheckProvider(provider, baseURL); violation != nil {
+		violation.Fatal()
+	}
+
+	apiKeyInput := options.APIKey
+	if options.APIKeyStdin {
+		line, err := stdinReader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", errors.Wrap(err, "reading API key from stdin")
+		}
+		apiKeyInput = strings.TrimSpace(line)
+	}
+
+	for attempt := 0; ; attempt++ {
+		if apiKeyInput == "" && interactive {
+			input, err := readPassword("API key, or a reference such as env:NAME or command:pass show openai (input is hidden): ")
+			if err != nil {
+				return "", err
+			}
+			apiKeyInput = strings.TrimSpace(input)
+		}
+		if apiKeyInput == "" {
+			if !interactive {
+				return "", errors.New("no API key given")
+			}
+			color.Yellow("The API key must not be empty.")
+			continue
+		}
+
+		apiKey := apiKeyInput
+		if isSecretReference(apiKeyInput) {
+			var err error
+			apiKey, err = resolveSecret(apiKeyInput)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		models, err := validateAPIKey(provider, baseURL, apiKey, options.SkipValidation)
+		if err != nil {
+			if !interactive || attempt >= 2 {
+				return "", err
+			}
+			color.Yellow("%v", err)
+			apiKeyInput = ""
+			continue
+		}
+
+		defaultModel, err := chooseDefaultModel(models, options.DefaultModel, interactive)
+		if err != nil {
+			return "", err
+		}
+		if violation := loadPolicy().CheckModel(provider, baseURL, defaultModel); violation != nil {
+			violation.Fatal()
+		}
+
+		config := readConfig()
+		if config.Providers == nil {
+			config.Providers = map[string]*ProviderConfig{}
+		}
+		config.Provider = provider
+		config.DefaultModel = defaultModel
+		config.Providers[provider] = &ProviderConfig{
+			BaseURL: baseURL,
+			APIKey:  storeAPIKey(provider, apiKeyInput),
+		}
+		config.OpenAI = nil
+		writeConfig(config)
+
+		fmt.Printf("Configuration written to %s (provider %s, default model %s)\n", configFilePath, provider, defaultModel)
+		return apiKey, nil
+	}
+}
+
+// validateAPIKey checks the key with a list-models call and returns the available models.
+func validateAPIKey(provider, baseURL, apiKey string, skip bool) ([]string, error) {
+	if skip {
+		return nil, nil
+	}
+	fmt.Printf("%s\r", color.YellowString("Validating API key ..."))
+	models, err := newModelLister(provider, baseURL, apiKey).GetAvailableModels()
+	fmt.Printf("%s\r", strings.Repeat(" ", 40))
+	if err != nil {
+		return nil, errors.Wrap(err, "API key validation failed")
+	}
+	color.Green("API key is valid, %d models available.", len(models))
+	return models, nil
+}
+
+func chooseDefaultModel(models []string, requested string, interactive bool) (string, error) {
+	sort.Strings(models)
+	if requested != "" {
+		if len(models) > 0 && !contains(models, requested) {
+			return "", fmt.Errorf("model %q is not offered by the provider", requested)
+		}
+		return requested, nil
+	}
+
+	suggestion := ""
+	for _, model := range preferredModels {
+		if len(models) == 0 || contains(models, model) {
+			suggestion = model
+			break
+		}
+	}
+	if suggestion == "" {
+		suggestion = models[0]
+	}
+	if !interactive {
+		return suggestion, nil
+	}
+	if len(models) == 0 {
+		return askLine("Default model", suggestion), nil
+	}
+	return askChoice("Default model", models, suggestion), nil
+}
+
+// askChoice shows a numbered list and accepts either a number or a literal value.
+func askChoice(label string, choices []string, defaultChoice string) string {
+	for i, choice := range choices {
+		fmt.Printf("%3d) %s\n", i+1, choice)
+	}
+	for {
+		answer := askLine(label, defaultChoice)
+		if index, err := strconv.Atoi(answer); err == nil && index >= 1 && index <= len(choices) {
+			return choices[index-1]
+		}
+		if contains(choices, answer) {
+			return answer
+		}
+		color.Yellow("Please enter a number between 1 and %d, or one of the listed values.", len(choices))
+	}
+}
+
+func askLine(label string, defaultValue string) string {
+	if defaultValue != "" {
+		fmt.Printf("%s [%s]: ", label, defaultValue)
+	} else {
+		fmt.Printf("%s: ", label)
+	}
+	line, err := stdinReader.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Fatalln(err)
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		if errors.Is(err, io.EOF) && defaultValue == "" {
+			log.Fatalln("Unexpected end of input")
+		}
+		return defaultValue
+	}
+	return line
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -37,13 +37,26 @@ func main() {
 			fmt.Println("Panic:", r)
 		}
 	}()
+	config := readConfig()
 	var modelFlag Model = "gpt-4-0613"
+	if config.DefaultModel != "" {
+		modelFlag = Model(config.DefaultModel)
+	}
 	flag.Var(&modelFlag, "model", "Model to use (e.g., gpt-4-0613 or gpt-3.5-turbo)")
 	debugFlag := flag.Bool("debug", false, "Enable debug mode")
 	executeFlag := flag.Bool("execute", false, "Execute the command instead of typing it out (dangerous!)")
+	previewFlag := flag.Bool("preview", false, "Run the command in a copy of the current directory, show the changed files, and ask before running it for real")
 	textFlag := flag.Bool("text", false, "Enable text mode")
 	gpt3Flag := flag.Bool("3", false, "Shorthand for --model=gpt-3.5-turbo")
 	initFlag := flag.Bool("init", false, "Initialize AI")
+	var initOptions InitOptions
+	flag.BoolVar(&initOptions.NonInteractive, "non-interactive", false, "With --init: configure from flags only, without asking questions")
+	flag.StringVar(&initOptions.Provider, "provider", "", "With --init: provider to use ("+strings.Join(providerNames, ", ")+")")
+	flag.StringVar(&initOptions.BaseURL, "base-url", "", "With --init: API base URL")
+	flag.StringVar(&initOptions.APIKey, "api-key-ref", "", "With --init: API key reference, such as env:NAME or command:pass show openai")
+	flag.BoolVar(&initOptions.APIKeyStdin, "api-key-stdin", false, "With --init: read the API key from stdin")
+	flag.StringVar(&initOptions.DefaultModel, "default-model", "", "With --init: default model")
+	flag.BoolVar(&initOptions.SkipValidation, "skip-validation", false, "With --init: don't validate the API key")
 	listModelsFlag := flag.Bool("list-models", false, "List available models")
 
 	// Add shorthands
@@ -52,12 +65,29 @@ func main() {
 	flag.BoolVar(executeFlag, "x", false, "Shorthand for execute")
 
 	flag.Parse()
+	debugContext = *debugFlag
+	if *gpt3Flag {
+		modelFlag = "gpt-3.5-turbo"
+	}
 
 	if initFlag != nil && *initFlag {
-		initApiKey()
+		runInit(initOptions)
+		if flag.NArg() == 0 {
+			os.Exit(0)
+		}
+		config = readConfig()
 	}
 
-	aiClient := NewAIClient(getAPIKey(), modelFlag.String())
+	if runSubcommand(flag.Args(), config, modelFlag.String()) {
+		os.Exit(0)
+	}
+
+	if *executeFlag || *previewFlag {
+		if violation := loadPolicy().CheckExecute(); violation != nil {
+			violation.Fatal()
+		}
+	}
+	aiClient := newConfiguredAIClient(config, modelFlag.String())
 
 	if *listModelsFlag {
 		listModels(aiClient)
@@ -65,9 +95,6 @@ func main() {
 	}
 
 	var mode = CommandMode
-	if *gpt3Flag {
-		modelFlag = "gpt-3.5-turbo"
-	}
 	if *textFlag {
 		mode = TextMode
 		if *debugFlag {
@@ -86,6 +113,8 @@ func main() {
 		userInput = strings.Join(args, " ")
 	} else {
 		fmt.Println("Usage: ai [options] <natural language command>")
+		fmt.Println("       ai [options] <subcommand>")
+		printSubcommands()
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
@@ -117,11 +146,18 @@ func main() {
 
 	var keyboard KeyboardInterface
 
-	if mode == CommandMode && !*executeFlag {
+	execute := *executeFlag || *previewFlag
+	if mode == CommandMode && !execute {
 		keyboard = NewKeyboard()
 	}
 
 	messages := generateChatGPTMessages(userInput, mode)
+	redactor := newRedactor(config)
+	if config.RedactionEnabled() {
+		messages = redactor.RedactMessages(messages)
+		redactor.PrintSummary()
+	}
+	audit := newAuditRecord(aiClient, messages, redactor)
 	if *debugFlag {
 		fmt.Println("Debug: Messages generated")
 		for i, msg := range messages {
@@ -135,23 +171,28 @@ func main() {
 	if mode == TextMode {
 		response, err := aiClient.ChatCompletion(messages)
 		if err != nil {
+			audit.Record("", nil, AuditFailed, err)
 			log.Fatalln(err)
 		}
 		if *debugFlag {
-			fmt.Printf("AI response (using model %s):\n", modelString)
+			fmt.Printf("AI response (using model %s, key %s):\n", modelString, aiClient.ServedBy)
 		}
-		fmt.Println(response)
+		fmt.Println(redactor.Restore(response))
+		audit.Record("", nil, AuditAnswered, nil)
 	} else {
 		chunkStream, err := aiClient.ChatCompletionStream(messages)
 		if err != nil {
+			audit.Record("", nil, AuditFailed, err)
 			panic(err)
 		}
 		defer chunkStream.Close()
 		if *debugFlag {
-			fmt.Println("Debug: Chat completion stream created")
+			fmt.Printf("Debug: Chat completion stream created (key %s)\n", aiClient.ServedBy)
 		}
 
 		var response = ""
+		// pending holds streamed text that may end in part of a redaction placeholder.
+		var pending = ""
 		var firstResponse = true
 		var functionName string
 		var functionArgs string
@@ -165,10 +206,12 @@ func main() {
 
 			chunkResponse, err := chunkStream.Recv()
 			if errors.Is(err, io.EOF) {
+				printChunk(redactor.Restore(pending), isInteractive)
 				break
 			}
 			if err != nil {
 				fmt.Printf("\nStream error: %v\n", err)
+				audit.Record("", nil, AuditFailed, err)
 				return
 			}
 
@@ -183,7 +226,9 @@ func main() {
 			} else {
 				chunk := chunkResponse.Choices[0].Delta.Content
 				response += chunk
-				printChunk(chunk, isInteractive)
+				var printable string
+				printable, pending = redactor.RestoreChunk(pending + chunk)
+				printChunk(printable, isInteractive)
 			}
 		}
 
@@ -201,15 +246,19 @@ func main() {
 			if err != nil {
 				log.Fatalln("Error parsing function arguments:", err)
 			}
+			returnCommand.Command = redactor.Restore(returnCommand.Command)
+			returnCommand.ModifiedPaths = redactor.RestoreAll(returnCommand.ModifiedPaths)
 
 			if returnCommand.Command == "" {
 				color.Yellow("No command returned. AI response:")
-				fmt.Println(response)
+				fmt.Println(redactor.Restore(response))
+				audit.Record("", nil, AuditDiscarded, nil)
 				return
 			}
 
 			// Print the command in blue
 			color.Blue(returnCommand.Command)
+			risk := checkCommand(returnCommand.Command, execute, config, audit)
 
 			// Check if required binaries are available
 			missingBinaries := checkBinaries(returnCommand.Binaries)
@@ -222,10 +271,17 @@ func main() {
 				alternativeMessages := append(messages, Message{Role: "user", Content: alternativeInput})
 
 				alternativeResponse, alternativeCommand := getAlternativeResponse(aiClient, alternativeMessages)
+				alternativeResponse = redactor.Restore(alternativeResponse)
+				if alternativeCommand != nil {
+					alternativeCommand.Command = redactor.Restore(alternativeCommand.Command)
+					alternativeCommand.ModifiedPaths = redactor.RestoreAll(alternativeCommand.ModifiedPaths)
+				}
 
 				if alternativeCommand != nil && alternativeCommand.Command != "" {
 					fmt.Println("\nAI's alternative command:")
 					fmt.Println(alternativeCommand.Command)
+					audit.Record(returnCommand.Command, &risk, AuditDiscarded, nil)
+					alternativeRisk := checkCommand(alternativeCommand.Command, execute, config, audit)
 
 					// Check if required binaries for the alternative command are available
 					missingBinaries := checkBinaries(alternativeCommand.Binaries)
@@ -233,23 +289,44 @@ func main() {
 						color.Yellow("The alternative command also requires missing binaries: %s", strings.Join(missingBinaries, ", "))
 						fmt.Println("\nAI's explanation:")
 						fmt.Println(alternativeResponse)
+						audit.Record(alternativeCommand.Command, &alternativeRisk, AuditDiscarded, nil)
 					} else {
-						if *executeFlag {
-							executeCommands([]string{alternativeCommand.Command}, shell)
+						if execute {
+							if *previewFlag && !previewAndConfirm([]string{alternativeCommand.Command}, shell) {
+								audit.Record(alternativeCommand.Command, &alternativeRisk, AuditDiscarded, nil)
+								return
+							}
+							takeUndoSnapshot(alternativeCommand.Command, alternativeCommand.ModifiedPaths, config)
+							err := executeCommands([]string{alternativeCommand.Command}, shell)
+							audit.Record(alternativeCommand.Command, &alternativeRisk, AuditExecuted, err)
+							if err != nil {
+								log.Fatalln(err)
+							}
 						} else {
 							typeCommands([]string{alternativeCommand.Command}, keyboard, shell)
+							audit.Record(alternativeCommand.Command, &alternativeRisk, AuditTyped, nil)
 						}
 					}
 				} else {
 					fmt.Println("\nAI's alternative response:")
 					fmt.Println(alternativeResponse)
+					audit.Record(returnCommand.Command, &risk, AuditDiscarded, nil)
 				}
 				return
 			}
 
 			executableCommands := []string{returnCommand.Command}
-			if *executeFlag {
-				executeCommands(executableCommands, shell)
+			if execute {
+				if *previewFlag && !previewAndConfirm(executableCommands, shell) {
+					audit.Record(returnCommand.Command, &risk, AuditDiscarded, nil)
+					return
+				}
+				takeUndoSnapshot(returnCommand.Command, returnCommand.ModifiedPaths, config)
+				err := executeCommands(executableCommands, shell)
+				audit.Record(returnCommand.Command, &risk, AuditExecuted, err)
+				if err != nil {
+					log.Fatalln(err)
+				}
 			} else {
 				if !keyboard.IsFocusTheSame() {
 					color.New(color.Faint).Println("Window focus changed during command generation.")
@@ -261,10 +338,12 @@ func main() {
 					}
 				}
 				typeCommands(executableCommands, keyboard, shell)
+				audit.Record(returnCommand.Command, &risk, AuditTyped, nil)
 			}
 		} else {
 			color.Yellow("No command returned. AI response:")
-			fmt.Println(response)
+			fmt.Println(redactor.Restore(response))
+			audit.Record("", nil, AuditDiscarded, nil)
 		}
 	}
 }
@@ -298,37 +377,60 @@ func printChunk(content string, isInteractive bool) {
 	fmt.Print(formattedContent)
 }
 
-func executeCommands(commands []string, shell string) {
+// executeCommands runs the commands in the shell, and stops at the first one that fails.
+func executeCommands(commands []string, shell string) error {
+	return executeCommandsIn(commands, shell, "")
+}
+
+// executeCommandsIn runs the commands in dir, or in the current directory when dir is empty.
+func executeCommandsIn(commands []string, shell string, dir string) error {
 	switch shell {
-	case "bash":
+	case "bash", "zsh", "sh", "dash", "ash", "ksh":
 		command := fmt.Sprintf("set -e\n%s", strings.Join(commands, "\n"))
-		err := executeCommand(command, shell)
-		if err != nil {
-			log.Fatalln(err)
-		}
-	case "powershell":
+		return executeCommand(command, shell, dir)
+	case "fish":
+		return executeCommand(strings.Join(commands, "\n"), shell, dir)
+	case "powershell", "pwsh":
 		for _, command := range commands {
-			err := executeCommand(command, shell)
+			err := executeCommand(command, shell, dir)
 			if err != nil {
-				log.Fatalln(err)
+				return err
 			}
 		}
+		return nil
 	default:
-		log.Fatalf("Unsupported shell: %s", shell)
+		return fmt.Errorf("unsupported shell: %s", shell)
+	}
+}
+
+// checkCommand applies the policy and the risk check to a returned command. It exits when the
+// policy blocks the command or the user declines it.
+func checkCommand(command string, execute bool, config Config, audit *AuditRecord) Risk {
+	if violation := loadPolicy().CheckCommand(command); violation != nil {
+		audit.Record(command, nil, AuditBlocked, nil)
+		violation.Fatal()
+	}
+	risk, confirmed := checkCommandRisk(command, execute, config)
+	if !confirmed {
+		audit.Record(command, &risk, AuditDiscarded, nil)
+		color.Yellow("Not executed.")
+		os.Exit(1)
 	}
+	return risk
 }
 
-func executeCommand(command string, shell string) error {
+func executeCommand(command string, shell string, dir string) error {
 	var cmd *exec.Cmd
 	switch shell {
-	case "bash":
-		cmd = exec.Command("bash")
+	case "bash", "zsh", "sh", "dash", "ash", "ksh", "fish":
+		cmd = exec.Command(shell)
 		cmd.Stdin = strings.NewReader(command)
-	case "powershell":
-		cmd = exec.Command("powershell", "-Command", command)
+	case "powershell", "pwsh":
+		cmd = exec.Command(shell, "-Command", command)
 	default:
 		return fmt.Errorf("unsupported shell: %s", shell)
 	}
+	cmd.Dir = dir
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
@@ -345,7 +447,7 @@ func typeCommands(executableCommands []string, keyboard KeyboardInterface, shell
 		return
 	}
 
-	if shell == "powershell" {
+	if shell == "powershell" || shell == "pwsh" {
 		if len(executableCommands) == 1 {
 			keyboard.SendString(executableCommands[0])
 			return
@@ -357,6 +459,18 @@ func typeCommands(executableCommands []string, keyboard KeyboardInterface, shell
 			keyboard.SendNewLine()
 		}
 		keyboard.SendString("}")
+	} else if shell == "fish" {
+		if len(executableCommands) == 1 {
+			keyboard.SendString(executableCommands[0])
+			return
+		}
+		keyboard.SendString("begin")
+		keyboard.SendNewLine()
+		for _, command := range executableCommands {
+			keyboard.SendString(command)
+			keyboard.SendNewLine()
+		}
+		keyboard.SendString("end")
 	} else {
 		if len(executableCommands) == 1 {
 			keyboard.SendString(executableCommands[0])
@@ -388,9 +502,19 @@ func checkBinaries(binaries []string) []string {
 }
 
 func getAlternativeResponse(aiClient *AIClient, messages []Message) (string, *ReturnCommandFunction) {
+	response, returnCommand, err := requestCommand(aiClient, messages)
+	if err != nil {
+		fmt.Printf("\nStream error: %v\n", err)
+		return "", nil
+	}
+	return response, returnCommand
+}
+
+// requestCommand sends the messages and collects the streamed response and the returned command, if any.
+func requestCommand(aiClient *AIClient, messages []Message) (string, *ReturnCommandFunction, error) {
 	chunkStream, err := aiClient.ChatCompletionStream(messages)
 	if err != nil {
-		panic(err)
+		return "", nil, err
 	}
 	defer chunkStream.Close()
 
@@ -405,8 +529,7 @@ func getAlternativeResponse(aiClient *AIClient, messages []Message) (string, *Re
 			break
 		}
 		if err != nil {
-			fmt.Printf("\nStream error: %v\n", err)
-			return "", nil
+			return "", nil, err
 		}
 
 		if chunkResponse.Choices[0].Delta.FunctionCall != nil {
@@ -426,9 +549,9 @@ func getAlternativeResponse(aiClient *AIClient, messages []Message) (string, *Re
 		err := json.Unmarshal([]byte(functionArgs), returnCommand)
 		if err != nil {
 			log.Println("Error parsing function arguments:", err)
-			return response, nil
+			return response, nil, nil
 		}
 	}
 
-	return response, returnCommand
+	return response, returnCommand, nil
 }
@@ -8,4 +8,6 @@ type Message struct {
 type ReturnCommandFunction struct {
 	Command  string   `json:"command"`
 	Binaries []string `json:"binaries"`
+	// ModifiedPaths are the files and directories the command creates, changes or deletes.
+	ModifiedPaths []string `json:"modified_paths"`
 }
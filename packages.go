@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+type packageManager struct {
+	Name     string
+	Binaries []string
+	// Native lists the os-release IDs (or GOOS values) for which this is the system package manager.
+	Native []string
+	// Language is set for managers of a programming language ecosystem, which rank last.
+	Language bool
+}
+
+// packageManagers are listed in order of preference within their rank.
+var packageManagers = []packageManager{
+	{Name: "apt", Binaries: []string{"apt-get"}, Native: []string{"debian", "ubuntu"}},
+	{Name: "dnf", Binaries: []string{"dnf"}, Native: []string{"fedora", "rhel", "centos"}},
+	{Name: "yum", Binaries: []string{"yum"}, Native: []string{"fedora", "rhel", "centos"}},
+	{Name: "pacman", Binaries: []string{"pacman"}, Native: []string{"arch"}},
+	{Name: "zypper", Binaries: []string{"zypper"}, Native: []string{"suse", "opensuse"}},
+	{Name: "apk", Binaries: []string{"apk"}, Native: []string{"alpine"}},
+	{Name: "nix", Binaries: []string{"nix-env", "nix"}, Native: []string{"nixos"}},
+	{Name: "brew", Binaries: []string{"brew"}, Native: []string{"darwin"}},
+	{Name: "port", Binaries: []string{"port"}, Native: []string{"darwin"}},
+	{Name: "winget", Binaries: []string{"winget"}, Native: []string{"windows"}},
+	{Name: "scoop", Binaries: []string{"scoop"}, Native: []string{"windows"}},
+	{Name: "choco", Binaries: []string{"choco"}, Native: []string{"windows"}},
+	{Name: "snap", Binaries: []string{"snap"}},
+	{Name: "flatpak", Binaries: []string{"flatpak"}},
+	{Name: "pipx", Binaries: []string{"pipx"}, Language: true},
+	{Name: "pip", Binaries: []string{"pip3", "pip"}, Language: true},
+	{Name: "npm", Binaries: []string{"npm"}, Language: true},
+	{Name: "cargo", Binaries: []string{"cargo"}, Language: true},
+	{Name: "go", Binaries: []string{"go"}, Language: true},
+}
+
+// distroFiles identify the distribution on systems without /etc/os-release.
+var distroFiles = map[string]string{
+	"/etc/debian_version": "debian",
+	"/etc/redhat-release": "rhel",
+	"/etc/fedora-release": "fedora",
+	"/etc/arch-release":   "arch",
+	"/etc/alpine-release": "alpine",
+	"/etc/SuSE-release":   "suse",
+	"/etc/NIXOS":          "nixos",
+}
+
+// gatherPackageManagers returns the installed package managers: the system's own first,
+// then distribution independent ones, then language package managers.
+func gatherPackageManagers(request ContextRequest) (interface{}, error) {
+	return detectPackageManagers(), nil
+}
+
+func detectPackageManagers() []string {
+	systemIDs := systemIdentifiers()
+
+	type rankedManager struct {
+		name  string
+		rank  int
+		index int
+	}
+	var found []rankedManager
+	for index, manager := range packageManagers {
+		if !anyBinaryInstalled(manager.Binaries) {
+			continue
+		}
+		rank := 1
+		if manager.Language {
+			rank = 2
+		} else if intersects(manager.Native, systemIDs) {
+			rank = 0
+		}
+		found = append(found, rankedManager{name: manager.Name, rank: rank, index: index})
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].rank != found[j].rank {
+			return found[i].rank < found[j].rank
+		}
+		return found[i].index < found[j].index
+	})
+
+	names := []string{}
+	for _, manager := range found {
+		names = append(names, manager.name)
+	}
+	return names
+}
+
+// systemIdentifiers returns GOOS and, on Linux, the distribution ID and the IDs it is like.
+func systemIdentifiers() []string {
+	ids := []string{runtime.GOOS}
+	if runtime.GOOS != "linux" {
+		return ids
+	}
+
+	osRelease := readOSRelease()
+	if osRelease["ID"] != "" {
+		ids = append(ids, osRelease["ID"])
+		ids = append(ids, strings.Fields(osRelease["ID_LIKE"])...)
+		return ids
+	}
+	for file, id := range distroFiles {
+		if _, err := os.Stat(file); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// readOSRelease parses /etc/os-release into a map, e.g. ID=ubuntu and PRETTY_NAME=Ubuntu 22.04.
+func readOSRelease() map[string]string {
+	values := map[string]string{}
+	for _, path := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			key, value, found := strings.Cut(scanner.Text(), "=")
+			if !found || strings.HasPrefix(key, "#") {
+				continue
+			}
+			values[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"'`)
+		}
+		break
+	}
+	return values
+}
+
+func anyBinaryInstalled(binaries []string) bool {
+	for _, binary := range binaries {
+		if _, err := exec.LookPath(binary); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func intersects(a, b []string) bool {
+	for _, value := range a {
+		if contains(b, value) {
+			return true
+		}
+	}
+	return false
+}
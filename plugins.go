@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Context plugins are executables named ai-context-* on PATH, or listed under plugins: in
+// ~/ai.yaml. Each is run with a PluginRequest as JSON on stdin, and prints a PluginResponse
+// as JSON on stdout.
+const (
+	pluginPrefix         = "ai-context-"
+	defaultPluginTimeout = 2 * time.Second
+)
+
+type PluginRequest struct {
+	Input string `json:"input"`
+	Cwd   string `json:"cwd"`
+	Shell string `json:"shell"`
+}
+
+type PluginResponse struct {
+	// Snippets are added to the system prompt as they are.
+	Snippets []string `json:"snippets"`
+}
+
+// PluginSnippet is a snippet together with the plugin that returned it.
+type PluginSnippet struct {
+	Plugin string
+	Text   string
+}
+
+// gatherPlugins runs the enabled plugins concurrently. A plugin that fails or times out is
+// reported and left out.
+func gatherPlugins(request ContextRequest) (interface{}, error) {
+	config := readConfig()
+	timeout := defaultPluginTimeout
+	if config.PluginTimeout > 0 {
+		timeout = time.Duration(config.PluginTimeout * float64(time.Second))
+	}
+
+	var plugins []string
+	for _, plugin := range findPlugins(config.Plugins) {
+		// Plugins are enabled or disabled by name, e.g. {ai-context-services: false}.
+		if contextEnabled(pluginName(plugin), true, request.WorkingDirectory) {
+			plugins = append(plugins, plugin)
+		}
+	}
+	if len(plugins) == 0 {
+		return nil, nil
+	}
+
+	input, err := json.Marshal(PluginRequest{Input: request.UserInput, Cwd: request.WorkingDirectory, Shell: request.Shell})
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]PluginResponse, len(plugins))
+	var wg sync.WaitGroup
+	for i, plugin := range plugins {
+		wg.Add(1)
+		go func(i int, plugin string) {
+			defer wg.Done()
+			response, err := runPlugin(plugin, input, timeout)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: context plugin %s failed: %v\n", pluginName(plugin), err)
+				return
+			}
+			responses[i] = response
+		}(i, plugin)
+	}
+	wg.Wait()
+
+	var snippets []PluginSnippet
+	for i, response := range responses {
+		for _, text := range response.Snippets {
+			if text = strings.TrimSpace(text); text != "" {
+				snippets = append(snippets, PluginSnippet{Plugin: pluginName(plugins[i]), Text: text})
+			}
+		}
+	}
+	if len(snippets) == 0 {
+		return nil, nil
+	}
+	return snippets, nil
+}
+
+func runPlugin(plugin string, input []byte, timeout time.Duration) (PluginResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var response PluginResponse
+	cmd := exec.CommandContext(ctx, plugin)
+	cmd.Stdin = bytes.NewReader(input)
+	// Don't wait for children of a killed plugin that still hold its output open.
+	cmd.WaitDelay = 100 * time.Millisecond
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if ctx.Err() != nil {
+		return response, fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		return response, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if err := json.Unmarshal(output, &response); err != nil {
+		return response, fmt.Errorf("invalid response: %v", err)
+	}
+	return response, nil
+}
+
+// findPlugins returns the plugins listed in the config, followed by the ai-context-*
+// executables on PATH. The first plugin with a given name wins.
+func findPlugins(configured []string) []string {
+	var plugins []string
+	seen := map[string]bool{}
+	add := func(plugin string) {
+		if name := pluginName(plugin); !seen[name] {
+			seen[name] = true
+			plugins = append(plugins, plugin)
+		}
+	}
+
+	for _, plugin := range configured {
+		path, err := exec.LookPath(plugin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: context plugin %s not found\n", plugin)
+			continue
+		}
+		add(path)
+	}
+
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), pluginPrefix) && !entry.IsDir() && isExecutable(entry) {
+				add(filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+	return plugins
+}
+
+// pluginName is the executable's name without directory and, on Windows, extension.
+func pluginName(plugin string) string {
+	name := filepath.Base(plugin)
+	if runtime.GOOS == "windows" {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name
+}
+
+func isExecutable(info os.FileInfo) bool {
+	if runtime.GOOS == "windows" {
+		extension := strings.ToLower(filepath.Ext(info.Name()))
+		for _, executable := range filepath.SplitList(firstNonEmpty(os.Getenv("PATHEXT"), ".COM;.EXE;.BAT;.CMD")) {
+			if extension == strings.ToLower(executable) {
+				return true
+			}
+		}
+		return false
+	}
+	return info.Mode()&0111 != 0
+}
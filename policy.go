@@ -0,0 +1,176 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/go-yaml/yaml"
+)
+
+// Policy is a machine-wide policy set by an administrator. Unlike ~/ai.yaml, its rules can't
+// be overridden by the user; rules that are not set don't restrict anything.
+type Policy struct {
+	// AllowedProviders lists the providers that may be used, e.g. [azure].
+	AllowedProviders []string `yaml:"allowed_providers"`
+	// AllowedBaseURLs lists the API endpoints that prompts may be sent to. Patterns such as
+	// https://*.openai.azure.com are allowed; an allowed URL also allows the paths below it.
+	AllowedBaseURLs []string `yaml:"allowed_base_urls"`
+	// AllowedModels lists the models that may be used. Patterns such as gpt-4o* are allowed.
+	AllowedModels []string `yaml:"allowed_models"`
+	// AllowExecute set to false forbids --execute.
+	AllowExecute *bool `yaml:"allow_execute"`
+	// DenyCommands are commands that are neither typed nor executed.
+	DenyCommands []PolicyPattern `yaml:"deny_commands"`
+	// RequireRedaction makes redaction mandatory, and RedactPatterns adds to the secret patterns.
+	RequireRedaction bool            `yaml:"require_redaction"`
+	RedactPatterns   []RedactPattern `yaml:"redact_patterns"`
+	// Context disables context providers and plugins by name, e.g. {git: false, cloud: false}.
+	Context map[string]bool `yaml:"context"`
+}
+
+type PolicyPattern struct {
+	Name  string `yaml:"name"`
+	Regex string `yaml:"regex"`
+	regex *regexp.Regexp
+}
+
+// PolicyViolation names the rule of the policy that a request breaks.
+type PolicyViolation struct {
+	Rule    string
+	Message string
+}
+
+var policyFilePath = getPolicyFilePath()
+
+func getPolicyFilePath() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(firstNonEmpty(os.Getenv("ProgramData"), `C:\ProgramData`), "ai", "policy.yaml")
+	}
+	return "/etc/ai/policy.yaml"
+}
+
+var (
+	policy     Policy
+	policyOnce sync.Once
+)
+
+// loadPolicy reads the policy file once. Without a policy file, nothing is restricted. A policy
+// file that can't be read is an error, so that a broken policy doesn't silently allow everything.
+func loadPolicy() Policy {
+	policyOnce.Do(func() {
+		data, err := ioutil.ReadFile(policyFilePath)
+		if os.IsNotExist(err) {
+			return
+		}
+		if err != nil {
+			log.Fatalf("Error reading policy file %s: %v", policyFilePath, err)
+		}
+		if err := yaml.Unmarshal(data, &policy); err != nil {
+			log.Fatalf("Error unmarshalling policy file %s: %v", policyFilePath, err)
+		}
+		for i, pattern := range policy.DenyCommands {
+			policy.DenyCommands[i].regex, err = regexp.Compile(pattern.Regex)
+			if err != nil {
+				log.Fatalf("Error in policy file %s, deny_commands %s: %v", policyFilePath, pattern.Name, err)
+			}
+		}
+	})
+	return policy
+}
+
+// CheckProvider checks the provider and the base URL that requests are sent to.
+func (p Policy) CheckProvider(provider, baseURL string) *PolicyViolation {
+	if len(p.AllowedProviders) > 0 && !contains(p.AllowedProviders, provider) {
+		return &PolicyViolation{Rule: "allowed_providers", Message: "provider " + provider + " is not allowed, use one of: " + strings.Join(p.AllowedProviders, ", ")}
+	}
+	if len(p.AllowedBaseURLs) == 0 {
+		return nil
+	}
+	for _, pattern := range p.AllowedBaseURLs {
+		if baseURLMatches(pattern, baseURL) {
+			return nil
+		}
+	}
+	return &PolicyViolation{Rule: "allowed_base_urls", Message: "base URL " + baseURL + " is not allowed, use one of: " + strings.Join(p.AllowedBaseURLs, ", ")}
+}
+
+// baseURLMatches compares the scheme, the host, which may be a pattern, and the path, which
+// may continue below the path of the pattern.
+func baseURLMatches(pattern, baseURL string) bool {
+	allowed, err := url.Parse(pattern)
+	if err != nil {
+		return false
+	}
+	actual, err := url.Parse(baseURL)
+	if err != nil || actual.User != nil {
+		return false
+	}
+	if !strings.EqualFold(allowed.Scheme, actual.Scheme) {
+		return false
+	}
+	if matched, _ := path.Match(strings.ToLower(allowed.Host), strings.ToLower(actual.Host)); !matched {
+		return false
+	}
+	allowedPath := strings.TrimSuffix(allowed.Path, "/")
+	actualPath := strings.TrimSuffix(actual.Path, "/")
+	return allowedPath == "" || actualPath == allowedPath || strings.HasPrefix(actualPath, allowedPath+"/")
+}
+
+// CheckModel checks the provider, base URL and model of a request.
+func (p Policy) CheckModel(provider, baseURL, model string) *PolicyViolation {
+	if violation := p.CheckProvider(provider, baseURL); violation != nil {
+		return violation
+	}
+	if len(p.AllowedModels) == 0 {
+		return nil
+	}
+	for _, pattern := range p.AllowedModels {
+		if matched, _ := path.Match(pattern, model); matched {
+			return nil
+		}
+	}
+	return &PolicyViolation{Rule: "allowed_models", Message: "model " + model + " is not allowed, use one of: " + strings.Join(p.AllowedModels, ", ")}
+}
+
+func (p Policy) CheckExecute() *PolicyViolation {
+	if p.AllowExecute != nil && !*p.AllowExecute {
+		return &PolicyViolation{Rule: "allow_execute", Message: "--execute is not allowed"}
+	}
+	return nil
+}
+
+// CheckCommand checks a returned command against the deny patterns.
+func (p Policy) CheckCommand(command string) *PolicyViolation {
+	for _, pattern := range p.DenyCommands {
+		if pattern.regex.MatchString(command) {
+			return &PolicyViolation{Rule: "deny_commands: " + firstNonEmpty(pattern.Name, pattern.Regex), Message: "the command is not allowed"}
+		}
+	}
+	return nil
+}
+
+// RedactionRequired tells whether redaction is mandatory, which it is when the policy adds patterns.
+func (p Policy) RedactionRequired() bool {
+	return p.RequireRedaction || len(p.RedactPatterns) > 0
+}
+
+// ContextDenied tells whether the policy disables a context provider or plugin.
+func (p Policy) ContextDenied(name string) bool {
+	enabled, ok := p.Context[name]
+	return ok && !enabled
+}
+
+// Fatal reports the violation and exits.
+func (v *PolicyViolation) Fatal() {
+	color.Red("Blocked by policy: %s (rule %s in %s)", v.Message, v.Rule, policyFilePath)
+	os.Exit(1)
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// previewMaxFiles limits the size of the directories that are copied for a preview.
+const previewMaxFiles = 50000
+
+// fileState is what a preview compares to find changed files.
+type fileState struct {
+	Size    int64
+	ModTime time.Time
+	Mode    fs.FileMode
+}
+
+// FileChange is a difference between the directory before and after a preview.
+type FileChange struct {
+	// Kind is "created", "modified", "deleted" or "renamed".
+	Kind string
+	Path string
+	// From is the original path of a renamed file.
+	From    string
+	OldSize int64
+	NewSize int64
+	IsDir   bool
+}
+
+// previewAndConfirm runs the commands in a temporary copy of the working directory, shows the
+// files they created, modified and deleted, and asks whether to run them for real.
+func previewAndConfirm(commands []string, shell string) bool {
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		color.Red("Can't preview: %v", err)
+		return false
+	}
+	if hazards := previewHazards(commands, workingDirectory); len(hazards) > 0 {
+		color.Yellow("The preview only copies %s, so running the command in it would still:", workingDirectory)
+		for _, hazard := range hazards {
+			color.Yellow("  - %s", hazard)
+		}
+		answer, err := readTerminalLine("Preview it anyway, doing these for real? [y/N] ")
+		if err != nil || (answer != "y" && answer != "yes") {
+			return false
+		}
+	}
+	changes, err := previewCommands(commands, shell, workingDirectory)
+	if err != nil {
+		color.Red("Can't preview: %v", err)
+		return false
+	}
+
+	printFileChanges(changes)
+	answer, err := readTerminalLine("Run it for real? [y/N] ")
+	return err == nil && (answer == "y" || answer == "yes")
+}
+
+func previewCommands(commands []string, shell string, workingDirectory string) ([]FileChange, error) {
+	count := 0
+	err := filepath.WalkDir(workingDirectory, func(path string, entry fs.DirEntry, err error) error {
+		count++
+		if count > previewMaxFiles {
+			return fmt.Errorf("the current directory has more than %d files", previewMaxFiles)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tempDir, err := ioutil.TempDir("", "ai-preview-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+	copyDir := filepath.Join(tempDir, filepath.Base(workingDirectory))
+	if err := copyDirectory(workingDirectory, copyDir); err != nil {
+		return nil, fmt.Errorf("copying %s: %v", workingDirectory, err)
+	}
+
+	before, err := snapshotDirectory(copyDir)
+	if err != nil {
+		return nil, err
+	}
+	color.New(color.Faint).Printf("Previewing in a copy of %s. Paths outside it are not protected.\n", workingDirectory)
+	err = executeCommandsIn(commands, shell, copyDir)
+	if err != nil {
+		color.Yellow("The command failed in the preview: %v", err)
+	}
+	after, err := snapshotDirectory(copyDir)
+	if err != nil {
+		return nil, err
+	}
+	return diffSnapshots(before, after, workingDirectory, copyDir), nil
+}
+
+// previewRemoteCommands change state outside the working directory, whatever their arguments.
+var previewRemoteCommands = map[string]string{
+	"curl": "make network requests", "wget": "make network requests", "ssh": "run commands on another machine",
+	"scp": "copy files to or from another machine", "kubectl": "change a Kubernetes cluster", "helm": "change a Kubernetes cluster",
+	"terraform": "change cloud resources", "aws": "change cloud resources", "az": "change cloud resources", "gcloud": "change cloud resources",
+	"gh": "change GitHub", "docker": "change containers and images", "podman": "change containers and images",
+	"apt": "change system packages", "apt-get": "change system packages", "yum": "change system packages", "dnf": "change system packages",
+	"pacman": "change system packages", "brew": "change system packages", "snap": "change system packages", "choco": "change system packages",
+	"winget": "change system packages", "systemctl": "change system services", "service": "change system services", "launchctl": "change system services",
+	"crontab": "change scheduled jobs", "shutdown": "shut down the machine", "reboot": "restart the machine",
+}
+
+// previewHazards finds what a command would do outside the copy of the working directory: use
+// paths outside it, change to another directory, run as root, or change remote or system state.
+func previewHazards(commands []string, workingDirectory string) []string {
+	var hazards []string
+	add := func(hazard string) {
+		if !contains(hazards, hazard) {
+			hazards = append(hazards, hazard)
+		}
+	}
+	for _, command := range commands {
+		for _, segment := range splitCommand(command) {
+			words := segment.Words
+			for len(words) > 0 && (contains(shellKeywords, words[0]) || words[0] == "env" || words[0] == "command" || strings.Contains(words[0], "=") && !strings.HasPrefix(words[0], "-")) {
+				words = words[1:]
+			}
+			if len(words) > 0 && (words[0] == "sudo" || words[0] == "doas") {
+				add("run as root with " + words[0])
+				words = skipFlags(words[1:])
+			}
+			if len(words) == 0 {
+				continue
+			}
+			name := filepath.Base(words[0])
+			switch {
+			case name == "cd" && (len(words) == 1 || escapesDirectory(words[1], workingDirectory)):
+				add("change to a directory outside the copy")
+			case name == "git" && len(nonFlags(words[1:])) > 0:
+				switch subcommand := nonFlags(words[1:])[0]; subcommand {
+				case "push", "pull", "fetch", "clone":
+					add("git " + subcommand + ", which talks to a remote")
+				}
+			case name == "rsync":
+				for _, arg := range nonFlags(words[1:]) {
+					if strings.Contains(arg, ":") {
+						add("copy files to or from another machine")
+					}
+				}
+			case previewRemoteCommands[name] != "":
+				add(previewRemoteCommands[name] + " with " + name)
+			}
+			for _, word := range words[1:] {
+				// Redirections and --option=path.
+				word = strings.TrimLeft(word, "0123456789&<>|")
+				if strings.HasPrefix(word, "-") && strings.Contains(word, "=") {
+					word = word[strings.Index(word, "=")+1:]
+				}
+				if escapesDirectory(word, workingDirectory) {
+					add("use " + word + ", outside the copy")
+				}
+			}
+		}
+	}
+	return hazards
+}
+
+// escapesDirectory tells whether a word is a path outside the working directory. Absolute paths
+// inside it count too, as they point to the original rather than to the copy.
+func escapesDirectory(word string, workingDirectory string) bool {
+	switch {
+	case word == "" || strings.ContainsAny(word, " \t\n") || strings.Contains(word, "://"):
+		return false
+	case word == "/dev/null" || word == "/dev/stdout" || word == "/dev/stderr":
+		return false
+	case word == "~" || strings.HasPrefix(word, "~/") || strings.Contains(word, "$HOME") || strings.Contains(word, "${HOME}"):
+		return true
+	case filepath.IsAbs(word) || strings.HasPrefix(word, "/"):
+		return true
+	}
+	relative, err := filepath.Rel(workingDirectory, filepath.Join(workingDirectory, word))
+	return err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// copyDirectory copies src to dst, using copy-on-write clones where the file system supports
+// them, so that even large directories are copied quickly.
+func copyDirectory(src, dst string) error {
+	var cp *exec.Cmd
+	switch runtime.GOOS {
+	case "linux":
+		cp = exec.Command("cp", "-a", "--reflink=auto", src, dst)
+	case "darwin":
+		// -c clones files with clonefile(2) on APFS.
+		cp = exec.Command("cp", "-c", "-R", "-p", src, dst)
+	}
+	if cp != nil && cp.Run() == nil {
+		return nil
+	}
+	os.RemoveAll(dst)
+	return copyTree(src, dst)
+}
+
+// copyTree copies a directory with its files, permissions, modification times and symlinks.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, relativePath)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			if err := copyFile(path, target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			return os.Chtimes(target, info.ModTime(), info.ModTime())
+		}
+		// Sockets, devices and pipes are left out.
+		return nil
+	})
+}
+
+func copyFile(src, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func snapshotDirectory(dir string) (map[string]fileState, error) {
+	snapshot := map[string]fileState{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// The command may have made parts of the copy unreadable.
+			return nil
+		}
+		if path == dir {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		relativePath, _ := filepath.Rel(dir, path)
+		snapshot[filepath.ToSlash(relativePath)] = fileState{Size: info.Size(), ModTime: info.ModTime(), Mode: info.Mode()}
+		return nil
+	})
+	return snapshot, err
+}
+
+// diffSnapshots compares the copy before and after the command. A deleted file whose content
+// reappears under another name is reported as renamed.
+func diffSnapshots(before, after map[string]fileState, originalDir, copyDir string) []FileChange {
+	var created, deleted, changes []FileChange
+	for path, old := range before {
+		current, exists := after[path]
+		switch {
+		case !exists:
+			deleted = append(deleted, FileChange{Kind: "deleted", Path: path, OldSize: old.Size, IsDir: old.Mode.IsDir()})
+		case old.Mode.IsDir() || current.Mode.IsDir():
+			if old.Mode.IsDir() != current.Mode.IsDir() {
+				changes = append(changes, FileChange{Kind: "modified", Path: path, OldSize: old.Size, NewSize: current.Size, IsDir: current.Mode.IsDir()})
+			}
+		case old.Size != current.Size || !old.ModTime.Equal(current.ModTime) || old.Mode != current.Mode:
+			changes = append(changes, FileChange{Kind: "modified", Path: path, OldSize: old.Size, NewSize: current.Size})
+		}
+	}
+	for path, current := range after {
+		if _, exists := before[path]; !exists {
+			created = append(created, FileChange{Kind: "created", Path: path, NewSize: current.Size, IsDir: current.Mode.IsDir()})
+		}
+	}
+
+	// Match deleted and created files with the same content.
+	renamed := map[string]bool{}
+	for _, deletion := range deleted {
+		matched := false
+		if !deletion.IsDir {
+			for i, creation := range created {
+				if creation.IsDir || renamed[creation.Path] || creation.NewSize != deletion.OldSize {
+					continue
+				}
+				if sameFileContent(filepath.Join(originalDir, deletion.Path), filepath.Join(copyDir, creation.Path)) {
+					renamed[creation.Path] = true
+					changes = append(changes, FileChange{Kind: "renamed", Path: creation.Path, From: deletion.Path, OldSize: deletion.OldSize, NewSize: created[i].NewSize})
+					matched = true
+					break
+				}
+			}
+		}
+		if !matched {
+			changes = append(changes, deletion)
+		}
+	}
+	for _, creation := range created {
+		if !renamed[creation.Path] {
+			changes = append(changes, creation)
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+func sameFileContent(a, b string) bool {
+	hashA, errA := fileHash(a)
+	hashB, errB := fileHash(b)
+	return errA == nil && errB == nil && bytes.Equal(hashA, hashB)
+}
+
+func fileHash(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+var fileChangeColors = map[string]*color.Color{
+	"created":  color.New(color.FgGreen),
+	"modified": color.New(color.FgYellow),
+	"deleted":  color.New(color.FgRed),
+	"renamed":  color.New(color.FgCyan),
+}
+
+func printFileChanges(changes []FileChange) {
+	if len(changes) == 0 {
+		fmt.Println("The command doesn't change any files in the current directory.")
+		return
+	}
+	fmt.Printf("The command changes %d files:\n", len(changes))
+	for _, change := range changes {
+		path := change.Path
+		if change.IsDir {
+			path += "/"
+		}
+		var details string
+		switch change.Kind {
+		case "created":
+			details = path
+			if !change.IsDir {
+				details += " (" + formatSize(change.NewSize) + ")"
+			}
+		case "deleted":
+			details = path
+			if !change.IsDir {
+				details += " (" + formatSize(change.OldSize) + ")"
+			}
+		case "modified":
+			details = fmt.Sprintf("%s (%s → %s)", path, formatSize(change.OldSize), formatSize(change.NewSize))
+		case "renamed":
+			details = fmt.Sprintf("%s → %s (%s)", change.From, path, formatSize(change.NewSize))
+		}
+		fileChangeColors[change.Kind].Printf("  %-8s %s\n", change.Kind, details)
+	}
+}
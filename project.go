@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+)
+
+// Project is a build system or ecosystem detected from a marker file, with the tasks it defines.
+type Project struct {
+	Type string
+	// Dir is relative to the working directory, e.g. "." or "..".
+	Dir string
+	// Tasks are complete commands, e.g. "make test" or "npm run lint".
+	Tasks    []string
+	Services []string
+}
+
+type projectDetector struct {
+	Type    string
+	Markers []string
+	Detect  func(dir, marker string) Project
+}
+
+var projectDetectors = []projectDetector{
+	{Type: "go", Markers: []string{"go.mod"}, Detect: detectGoProject},
+	{Type: "node", Markers: []string{"package.json"}, Detect: detectNodeProject},
+	{Type: "rust", Markers: []string{"Cargo.toml"}, Detect: detectRustProject},
+	{Type: "python", Markers: []string{"pyproject.toml"}, Detect: detectPythonProject},
+	{Type: "make", Markers: []string{"GNUmakefile", "Makefile", "makefile"}, Detect: detectMakeProject},
+	{Type: "just", Markers: []string{"justfile", "Justfile", ".justfile"}, Detect: detectJustProject},
+	{Type: "docker-compose", Markers: []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}, Detect: detectComposeProject},
+}
+
+const maxProjectTasks = 30
+
+var (
+	makeTargetRegex  = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9_.\-/]*)\s*:([^=]|$)`)
+	justRecipeRegex  = regexp.MustCompile(`^@?([A-Za-z0-9_-]+)(\s[^:]*)?:([^=]|$)`)
+	tomlSectionRegex = regexp.MustCompile(`^\s*\[([^\]]+)\]\s*$`)
+	tomlKeyRegex     = regexp.MustCompile(`^\s*"?([A-Za-z0-9_.-]+)"?\s*=`)
+)
+
+// gatherProjects looks for marker files in the working directory and its parents, up to and
+// including the home directory. For each project type, only the nearest project is reported.
+func gatherProjects(request ContextRequest) (interface{}, error) {
+	var projects []Project
+	found := map[string]bool{}
+
+	dir := request.WorkingDirectory
+	for {
+		for _, detector := range projectDetectors {
+			if found[detector.Type] {
+				continue
+			}
+			for _, marker := range detector.Markers {
+				if _, err := os.Stat(filepath.Join(dir, marker)); err != nil {
+					continue
+				}
+				project := detector.Detect(dir, marker)
+				project.Type = detector.Type
+				project.Dir, _ = filepath.Rel(request.WorkingDirectory, dir)
+				if len(project.Tasks) > maxProjectTasks {
+					project.Tasks = project.Tasks[:maxProjectTasks]
+				}
+				projects = append(projects, project)
+				found[detector.Type] = true
+				break
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if dir == homeDir || parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	if len(projects) == 0 {
+		return nil, nil
+	}
+	return projects, nil
+}
+
+func detectGoProject(dir, marker string) Project {
+	return Project{Tasks: []string{"go build ./...", "go test ./...", "go vet ./..."}}
+}
+
+func detectNodeProject(dir, marker string) Project {
+	runner := "npm run"
+	switch {
+	case fileExists(filepath.Join(dir, "pnpm-lock.yaml")):
+		runner = "pnpm run"
+	case fileExists(filepath.Join(dir, "yarn.lock")):
+		runner = "yarn"
+	case fileExists(filepath.Join(dir, "bun.lockb")):
+		runner = "bun run"
+	}
+
+	var packageJSON struct {
+		Scripts map[string]string `json:"scripts"`
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, marker))
+	if err != nil || json.Unmarshal(data, &packageJSON) != nil {
+		return Project{}
+	}
+	var tasks []string
+	for _, name := range sortedKeys(packageJSON.Scripts) {
+		tasks = append(tasks, runner+" "+name)
+	}
+	return Project{Tasks: tasks}
+}
+
+func detectRustProject(dir, marker string) Project {
+	return Project{Tasks: []string{"cargo build", "cargo test", "cargo run"}}
+}
+
+func detectPythonProject(dir, marker string) Project {
+	sections := readTOMLKeys(filepath.Join(dir, marker))
+	runner := ""
+	switch {
+	case fileExists(filepath.Join(dir, "uv.lock")):
+		runner = "uv run "
+	case sections["tool.poetry"] != nil:
+		runner = "poetry run "
+	}
+
+	var tasks []string
+	for _, section := range []string{"project.scripts", "tool.poetry.scripts"} {
+		for _, name := range sections[section] {
+			tasks = append(tasks, runner+name)
+		}
+	}
+	for _, name := range sections["tool.poe.tasks"] {
+		tasks = append(tasks, "poe "+name)
+	}
+	if _, ok := sections["tool.pytest.ini_options"]; ok {
+		tasks = append(tasks, runner+"pytest")
+	}
+	return Project{Tasks: tasks}
+}
+
+func detectMakeProject(dir, marker string) Project {
+	var tasks []string
+	for _, target := range matchLines(filepath.Join(dir, marker), makeTargetRegex) {
+		if !strings.HasPrefix(target, ".") && !strings.Contains(target, "/") && !strings.Contains(target, ".") {
+			tasks = append(tasks, "make "+target)
+		}
+	}
+	return Project{Tasks: tasks}
+}
+
+func detectJustProject(dir, marker string) Project {
+	var tasks []string
+	for _, recipe := range matchLines(filepath.Join(dir, marker), justRecipeRegex) {
+		if recipe != "set" && recipe != "export" && recipe != "alias" {
+			tasks = append(tasks, "just "+recipe)
+		}
+	}
+	return Project{Tasks: tasks}
+}
+
+func detectComposeProject(dir, marker string) Project {
+	var compose struct {
+		Services map[string]interface{} `yaml:"services"`
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, marker))
+	if err != nil || yaml.Unmarshal(data, &compose) != nil {
+		return Project{}
+	}
+	services := []string{}
+	for name := range compose.Services {
+		services = append(services, name)
+	}
+	sort.Strings(services)
+	return Project{Tasks: []string{"docker compose up -d", "docker compose logs -f", "docker compose down"}, Services: services}
+}
+
+// matchLines returns the first submatch of the regex for each matching line, without duplicates.
+func matchLines(path string, regex *regexp.Regexp) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var matches []string
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		match := regex.FindStringSubmatch(scanner.Text())
+		if match != nil && !seen[match[1]] {
+			seen[match[1]] = true
+			matches = append(matches, match[1])
+		}
+	}
+	return matches
+}
+
+// readTOMLKeys returns the keys per section of a TOML file. It only understands the simple
+// `[section]` and `key = value` lines that pyproject.toml files use.
+func readTOMLKeys(path string) map[string][]string {
+	sections := map[string][]string{}
+	file, err := os.Open(path)
+	if err != nil {
+		return sections
+	}
+	defer file.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := tomlSectionRegex.FindStringSubmatch(line); match != nil {
+			section = strings.TrimSpace(match[1])
+			if _, ok := sections[section]; !ok {
+				sections[section] = []string{}
+			}
+		} else if match := tomlKeyRegex.FindStringSubmatch(line); match != nil && !strings.HasPrefix(line, " ") {
+			sections[section] = append(sections[section], match[1])
+		}
+	}
+	return sections
+}
+
+func sortedKeys(values map[string]string) []string {
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
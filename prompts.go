@@ -1,12 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
 	"strings"
+	"text/template"
 
 	"github.com/go-yaml/yaml"
 	"github.com/pkg/errors"
@@ -17,9 +22,12 @@ type Shell struct {
 }
 
 type Prompts struct {
-	Bash       Shell `yaml:"bash"`
-	Powershell Shell `yaml:"powershell"`
-	Command    struct {
+	// Shells holds the few-shot examples per shell, keyed by the shell's process name.
+	Shells map[string]Shell `yaml:"shells"`
+	// Fallbacks maps a shell without its own examples to a similar shell, e.g. dash to sh.
+	// Fallbacks are followed until a shell with examples is found.
+	Fallbacks map[string]string `yaml:"fallbacks"`
+	Command   struct {
 		Messages []Message `yaml:"messages"`
 	} `yaml:"command"`
 	Text struct {
@@ -35,13 +43,28 @@ const (
 )
 
 func generateChatGPTMessages(userInput string, mode Mode) []Message {
-	shell := getShellCached()
-	shellVersion := getShellVersion(shell)
-	systemInfo := runtime.GOOS
+	return generateChatGPTMessagesForShell(userInput, mode, getShellCached())
+}
+
+func generateChatGPTMessagesForShell(userInput string, mode Mode, shell string) []Message {
 	workingDirectory, _ := os.Getwd()
-	packageManagers := []string{} // This should be implemented based on the OS
-	sudo := false // This should be implemented based on the OS
 
+	templateData := map[string]interface{}{
+		"shell":             shell,
+		"working_directory": workingDirectory,
+		"vars":              promptVariables(),
+	}
+	gatherContext(ContextRequest{
+		UserInput:        userInput,
+		Shell:            shell,
+		WorkingDirectory: workingDirectory,
+	}, templateData)
+	return renderPromptMessages(userInput, mode, shell, templateData)
+}
+
+// renderPromptMessages builds the messages for a request from prompts.yaml and the given
+// template data.
+func renderPromptMessages(userInput string, mode Mode, shell string, templateData map[string]interface{}) []Message {
 	prompts := Prompts{}
 
 	aiHome := getAiHome()
@@ -56,10 +79,7 @@ func generateChatGPTMessages(userInput string, mode Mode) []Message {
 		panic(err)
 	}
 
-	shellMessages := prompts.Bash.Messages
-	if shell == "powershell" {
-		shellMessages = prompts.Powershell.Messages
-	}
+	shellMessages := prompts.ShellMessages(shell)
 
 	var commonMessages []Message
 	if mode == CommandMode {
@@ -68,22 +88,6 @@ func generateChatGPTMessages(userInput string, mode Mode) []Message {
 		commonMessages = prompts.Text.Messages
 	}
 
-	for i := range commonMessages {
-		commonMessages[i].Content = strings.NewReplacer(
-			"{shell}", shell,
-			"{shell_version}", shellVersion,
-			"{system_info}", systemInfo,
-			"{working_directory}", workingDirectory,
-			"{package_managers}", strings.Join(packageManagers, ", "),
-			"{sudo}", func() string {
-				if sudo {
-					return "sudo"
-				}
-				return "no sudo"
-			}(),
-		).Replace(commonMessages[i].Content)
-	}
-
 	userMessage := Message{
 		Role:    "user",
 		Content: userInput,
@@ -99,11 +103,71 @@ func generateChatGPTMessages(userInput string, mode Mode) []Message {
 		outputMessages = append(outputMessages, shellMessages...)
 	}
 
+	for i := range outputMessages {
+		outputMessages[i].Content, err = renderPromptTemplate(fmt.Sprintf("message %d", i+1), outputMessages[i].Content, templateData)
+		if err != nil {
+			log.Fatalf("Error in %s: %v", promptsFilePath, err)
+		}
+	}
+
 	// add user message
 	outputMessages = append(outputMessages, userMessage)
 	return outputMessages
 }
 
+const defaultPromptShell = "bash"
+
+// ShellMessages returns the examples for the shell, following the fallback chain and
+// ending with bash when no similar shell has examples.
+func (p Prompts) ShellMessages(shell string) []Message {
+	visited := map[string]bool{}
+	for name := shell; name != "" && !visited[name]; name = p.Fallbacks[name] {
+		visited[name] = true
+		if promptShell, ok := p.Shells[name]; ok {
+			return promptShell.Messages
+		}
+	}
+	return p.Shells[defaultPromptShell].Messages
+}
+
+var unknownVariableRegex = regexp.MustCompile(`map has no entry for key "([^"]*)"`)
+
+// renderPromptTemplate renders a prompt written in text/template syntax. Referencing a
+// variable that doesn't exist is an error rather than an empty string.
+func renderPromptTemplate(name, text string, data map[string]interface{}) (string, error) {
+	tmpl, err := template.New(name).
+		Option("missingkey=error").
+		Funcs(template.FuncMap{"join": strings.Join}).
+		Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var output bytes.Buffer
+	err = tmpl.Execute(&output, data)
+	if err != nil {
+		if match := unknownVariableRegex.FindStringSubmatch(err.Error()); match != nil {
+			var known []string
+			for key := range data {
+				known = append(known, key)
+			}
+			sort.Strings(known)
+			return "", fmt.Errorf("%s references unknown variable %q (known variables: %s; user-defined variables are available as .vars.<name>)", name, match[1], strings.Join(known, ", "))
+		}
+		return "", err
+	}
+	return output.String(), nil
+}
+
+// promptVariables returns the user-defined prompt variables from the config file.
+func promptVariables() map[string]string {
+	variables := readConfig().PromptVariables
+	if variables == nil {
+		variables = map[string]string{}
+	}
+	return variables
+}
+
 func getAiHome() string {
 	aiHome := os.Getenv("AI_HOME")
 	if aiHome == "" || strings.Contains(aiHome, "go-build") {
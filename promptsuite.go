@@ -0,0 +1,349 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/go-yaml/yaml"
+	"github.com/pkg/errors"
+)
+
+// PromptSuite is a set of natural language requests with expectations on the returned command.
+type PromptSuite struct {
+	// Shell is used for cases that don't set their own.
+	Shell string `yaml:"shell"`
+	// Context sets template variables such as system_info, sudo or tools, in the form the context
+	// providers return them. The providers don't run for the suite, so that results don't depend
+	// on the machine.
+	Context map[string]interface{} `yaml:"context,omitempty"`
+	Cases   []PromptTestCase       `yaml:"cases"`
+}
+
+type PromptTestCase struct {
+	Name  string `yaml:"name"`
+	Input string `yaml:"input"`
+	Shell string `yaml:"shell,omitempty"`
+	// Context overrides variables of the suite's context for this case.
+	Context map[string]interface{} `yaml:"context,omitempty"`
+	// MustMatch and MustNotMatch are regular expressions applied to the command.
+	MustMatch    []string `yaml:"must_match,omitempty"`
+	MustNotMatch []string `yaml:"must_not_match,omitempty"`
+	// Binaries must all be listed in the binaries returned with the command.
+	Binaries []string `yaml:"binaries,omitempty"`
+	// Parse checks the command with the shell's own parser (e.g. `bash -n`). Defaults to true.
+	Parse *bool `yaml:"parse,omitempty"`
+}
+
+// RecordedResponse is a command returned for an input, saved with --record and replayed with --recorded.
+type RecordedResponse struct {
+	Shell    string   `yaml:"shell"`
+	Input    string   `yaml:"input"`
+	Command  string   `yaml:"command"`
+	Binaries []string `yaml:"binaries,omitempty"`
+}
+
+type RecordedResponses struct {
+	Responses []RecordedResponse `yaml:"responses"`
+}
+
+type CommandBackend interface {
+	Command(messages []Message, shell, input string) (*ReturnCommandFunction, error)
+}
+
+type liveBackend struct {
+	aiClient *AIClient
+}
+
+func (b liveBackend) Command(messages []Message, shell, input string) (*ReturnCommandFunction, error) {
+	response, returnCommand, err := requestCommand(b.aiClient, messages)
+	if err != nil {
+		return nil, err
+	}
+	if returnCommand == nil {
+		return nil, fmt.Errorf("no command returned, response: %s", response)
+	}
+	return returnCommand, nil
+}
+
+type recordedBackend struct {
+	responses RecordedResponses
+}
+
+func (b recordedBackend) Command(messages []Message, shell, input string) (*ReturnCommandFunction, error) {
+	for _, response := range b.responses.Responses {
+		if response.Shell == shell && response.Input == input {
+			return &ReturnCommandFunction{Command: response.Command, Binaries: response.Binaries}, nil
+		}
+	}
+	return nil, fmt.Errorf("no recorded response for %q in %s", input, shell)
+}
+
+// recordingBackend passes requests to another backend and keeps the responses.
+type recordingBackend struct {
+	backend   CommandBackend
+	responses *RecordedResponses
+}
+
+func (b recordingBackend) Command(messages []Message, shell, input string) (*ReturnCommandFunction, error) {
+	returnCommand, err := b.backend.Command(messages, shell, input)
+	if err == nil {
+		b.responses.Responses = append(b.responses.Responses, RecordedResponse{
+			Shell: shell, Input: input, Command: returnCommand.Command, Binaries: returnCommand.Binaries,
+		})
+	}
+	return returnCommand, err
+}
+
+func runPromptsTest(args []string, config Config, model string) {
+	flags := newSubcommandFlagSet("prompts test")
+	suitePath := flags.String("suite", filepath.Join(getAiHome(), "prompts.suite.yaml"), "Test suite file")
+	recordedPath := flags.String("recorded", "", "Replay responses from this file instead of calling the model")
+	recordPath := flags.String("record", "", "Save the model's responses to this file")
+	shellFlag := flags.String("shell", "", "Run all cases for this shell")
+	runFlag := flags.String("run", "", "Only run cases whose name matches this regular expression")
+	flags.Parse(args)
+
+	suite, err := readPromptSuite(*suitePath)
+	if err != nil {
+		color.Red("%v", err)
+		os.Exit(1)
+	}
+	var runRegex *regexp.Regexp
+	if *runFlag != "" {
+		runRegex, err = regexp.Compile(*runFlag)
+		if err != nil {
+			log.Fatalf("Invalid --run pattern: %v", err)
+		}
+	}
+
+	var backend CommandBackend
+	if *recordedPath != "" {
+		var responses RecordedResponses
+		err := readYAMLFile(*recordedPath, &responses)
+		if err != nil {
+			color.Red("%v", err)
+			os.Exit(1)
+		}
+		backend = recordedBackend{responses: responses}
+		fmt.Printf("Running %s against recorded responses from %s\n\n", *suitePath, *recordedPath)
+	} else {
+		backend = liveBackend{aiClient: newConfiguredAIClient(config, model)}
+		fmt.Printf("Running %s against model %s\n\n", *suitePath, model)
+	}
+	recorded := &RecordedResponses{}
+	if *recordPath != "" {
+		backend = recordingBackend{backend: backend, responses: recorded}
+	}
+
+	passed, failed := 0, 0
+	for _, testCase := range suite.Cases {
+		if runRegex != nil && !runRegex.MatchString(testCase.Name) {
+			continue
+		}
+		shell := firstNonEmpty(*shellFlag, testCase.Shell, suite.Shell, defaultPromptShell)
+		messages := renderPromptMessages(testCase.Input, CommandMode, shell, suite.templateData(shell, testCase))
+
+		returnCommand, err := backend.Command(messages, shell, testCase.Input)
+		var failures []string
+		if err != nil {
+			failures = []string{err.Error()}
+		} else {
+			failures = checkPromptTestCase(testCase, shell, returnCommand)
+		}
+
+		if len(failures) == 0 {
+			passed++
+			fmt.Printf("%s %s [%s]\n", color.GreenString("PASS"), testCase.Name, shell)
+		} else {
+			failed++
+			fmt.Printf("%s %s [%s]\n", color.RedString("FAIL"), testCase.Name, shell)
+			if returnCommand != nil {
+				fmt.Printf("     command: %s\n", returnCommand.Command)
+			}
+			for _, failure := range failures {
+				fmt.Printf("     - %s\n", failure)
+			}
+		}
+	}
+
+	if *recordPath != "" {
+		err := writeYAMLFile(*recordPath, recorded)
+		if err != nil {
+			color.Red("Error writing recorded responses: %v", err)
+		} else {
+			fmt.Printf("\nRecorded %d responses to %s\n", len(recorded.Responses), *recordPath)
+		}
+	}
+
+	fmt.Printf("\n%d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// checkPromptTestCase returns the expectations that the command doesn't meet.
+func checkPromptTestCase(testCase PromptTestCase, shell string, returnCommand *ReturnCommandFunction) []string {
+	var failures []string
+	for _, pattern := range testCase.MustMatch {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("invalid regex %q: %v", pattern, err))
+		} else if !regex.MatchString(returnCommand.Command) {
+			failures = append(failures, fmt.Sprintf("does not match %q", pattern))
+		}
+	}
+	for _, pattern := range testCase.MustNotMatch {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("invalid regex %q: %v", pattern, err))
+		} else if regex.MatchString(returnCommand.Command) {
+			failures = append(failures, fmt.Sprintf("matches %q", pattern))
+		}
+	}
+	for _, binary := range testCase.Binaries {
+		if !contains(returnCommand.Binaries, binary) {
+			failures = append(failures, fmt.Sprintf("binary %s not in required binaries %v", binary, returnCommand.Binaries))
+		}
+	}
+	if testCase.Parse == nil || *testCase.Parse {
+		if err := parseCommand(shell, returnCommand.Command); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	return failures
+}
+
+// parseCommand checks the syntax of a command with the shell's no-exec mode. Shells that
+// have no such mode, or aren't installed, are not checked.
+func parseCommand(shell, command string) error {
+	switch shell {
+	case "bash", "zsh", "sh", "dash", "ash", "ksh", "fish":
+	default:
+		return nil
+	}
+	if _, err := exec.LookPath(shell); err != nil {
+		return nil
+	}
+	cmd := exec.Command(shell, "-n")
+	cmd.Stdin = strings.NewReader(command)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s parser rejects the command: %s", shell, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// suiteWorkingDirectory is the working directory in the prompts unless the suite sets one.
+const suiteWorkingDirectory = "/home/user/project"
+
+// suiteContextTypes are the types of the values that context providers return. Values set in a
+// suite are decoded into them, so that templates such as {{.sudo.Level}} and {{range .tools}}
+// see the same types as with gathered context.
+var suiteContextTypes = map[string]interface{}{
+	"package_managers": []string{},
+	"sudo":             SudoAccess{},
+	"tools":            []Tool{},
+	"git":              &GitContext{},
+	"projects":         []Project{},
+	"cloud":            &CloudContext{},
+	"aliases":          []ShellAlias{},
+	"history":          []string{},
+	"files":            &DirectoryListing{},
+	"plugins":          []PluginSnippet{},
+}
+
+// templateData returns the pinned template data for a case: every context provider is empty
+// unless the suite or the case sets it.
+func (s PromptSuite) templateData(shell string, testCase PromptTestCase) map[string]interface{} {
+	data := map[string]interface{}{
+		"shell":             shell,
+		"working_directory": suiteWorkingDirectory,
+		"vars":              map[string]string{},
+	}
+	for _, provider := range contextProviders {
+		data[provider.Name] = nil
+	}
+	for _, context := range []map[string]interface{}{s.Context, testCase.Context} {
+		for name, value := range context {
+			data[name] = value
+		}
+	}
+	return data
+}
+
+// decodeSuiteContext converts the values of a context read from YAML to the types of the context
+// providers.
+func decodeSuiteContext(context map[string]interface{}) error {
+	for name, value := range context {
+		sample, ok := suiteContextTypes[name]
+		if !ok {
+			continue
+		}
+		data, err := yaml.Marshal(value)
+		if err != nil {
+			return errors.Wrapf(err, "context %s", name)
+		}
+		typed := reflect.New(reflect.TypeOf(sample))
+		if err := yaml.UnmarshalStrict(data, typed.Interface()); err != nil {
+			return errors.Wrapf(err, "context %s", name)
+		}
+		context[name] = typed.Elem().Interface()
+	}
+	return nil
+}
+
+func readPromptSuite(path string) (PromptSuite, error) {
+	var suite PromptSuite
+	err := readYAMLFile(path, &suite)
+	if err != nil {
+		return suite, err
+	}
+	if err := decodeSuiteContext(suite.Context); err != nil {
+		return suite, errors.Wrap(err, path)
+	}
+	for i, testCase := range suite.Cases {
+		if testCase.Input == "" {
+			return suite, fmt.Errorf("%s: case %d has no input", path, i+1)
+		}
+		if err := decodeSuiteContext(testCase.Context); err != nil {
+			return suite, errors.Wrapf(err, "%s: case %d", path, i+1)
+		}
+		if testCase.Name == "" {
+			suite.Cases[i].Name = testCase.Input
+		}
+	}
+	return suite, nil
+}
+
+func readYAMLFile(path string, value interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return errors.Wrapf(yaml.Unmarshal(data, value), "parsing %s", path)
+}
+
+func writeYAMLFile(path string, value interface{}) error {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
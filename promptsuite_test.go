@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestPromptSuiteRecorded replays the committed responses against the suite, so that changes to
+// the checks or the pinned context are tested without calling a model.
+func TestPromptSuiteRecorded(t *testing.T) {
+	suite, err := readPromptSuite("prompts.suite.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var responses RecordedResponses
+	if err := readYAMLFile("prompts.suite.recorded.yaml", &responses); err != nil {
+		t.Fatal(err)
+	}
+	backend := recordedBackend{responses: responses}
+
+	for _, testCase := range suite.Cases {
+		shell := firstNonEmpty(testCase.Shell, suite.Shell, defaultPromptShell)
+		messages := renderPromptMessages(testCase.Input, CommandMode, shell, suite.templateData(shell, testCase))
+		returnCommand, err := backend.Command(messages, shell, testCase.Input)
+		if err != nil {
+			t.Errorf("%s: %v", testCase.Name, err)
+			continue
+		}
+		if failures := checkPromptTestCase(testCase, shell, returnCommand); len(failures) > 0 {
+			t.Errorf("%s: %s", testCase.Name, strings.Join(failures, "; "))
+		}
+	}
+}
+
+func TestPromptSuiteContextReachesTemplates(t *testing.T) {
+	suite, err := readPromptSuite("prompts.suite.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"sudo-passwordless-install": "can use sudo without a password",
+		"root-install-without-sudo": "The user is root",
+		"prefers-installed-fdfind":  "- fdfind 8.3.1",
+		"git-push-without-upstream": "Current branch: feature/login. The branch has no upstream.",
+	}
+	for _, testCase := range suite.Cases {
+		text, ok := expected[testCase.Name]
+		if !ok {
+			continue
+		}
+		delete(expected, testCase.Name)
+		shell := firstNonEmpty(testCase.Shell, suite.Shell, defaultPromptShell)
+		messages := renderPromptMessages(testCase.Input, CommandMode, shell, suite.templateData(shell, testCase))
+		if !strings.Contains(messages[0].Content, text) {
+			t.Errorf("%s: system prompt doesn't contain %q:\n%s", testCase.Name, text, messages[0].Content)
+		}
+	}
+	for name := range expected {
+		t.Errorf("case %s not in the suite", name)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// redactionRule matches a kind of secret. When the regex has groups named "secret", only the
+// one that matched is redacted, so that e.g. "password=" stays readable.
+type redactionRule struct {
+	Name  string
+	Regex *regexp.Regexp
+}
+
+var redactionRules = []redactionRule{
+	{Name: "private key", Regex: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)},
+	{Name: "AWS access key", Regex: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{Name: "AWS secret key", Regex: regexp.MustCompile(`(?i)aws_secret_access_key\s*[=:]\s*["']?(?P<secret>[A-Za-z0-9/+=]{40})`)},
+	{Name: "GitHub token", Regex: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{Name: "OpenAI key", Regex: regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}`)},
+	{Name: "Slack token", Regex: regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{Name: "JWT", Regex: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)},
+	{Name: "bearer token", Regex: regexp.MustCompile(`(?i)\bbearer\s+(?P<secret>[A-Za-z0-9._~+/-]{8,}=*)`)},
+	{Name: "URL password", Regex: regexp.MustCompile(`://[^/\s:@]+:(?P<secret>[^@\s/]+)@`)},
+	// Assignments such as PASSWORD=value redact any value. After ": " or " = ", the value must look
+	// like a secret, containing a digit or symbol or being long, so that prose isn't redacted.
+	{Name: "password", Regex: regexp.MustCompile(`(?i)(?:password|passwd|secret|token|api[_-]?key)["']?(?:=(?P<secret>"[^"]+"|'[^']+'|[^\s"']+)|\s*[=:]\s*(?P<secret>"[^"]+"|'[^']+'|[^\s"']*[^A-Za-z\s"',.;:!?][^\s"']*|[A-Za-z]{16,}))`)},
+	{Name: "password flag", Regex: regexp.MustCompile(`(?i)--(?:password|passwd|token|secret|api-key)[= ](?P<secret>\S+)`)},
+}
+
+const redactedText = "[REDACTED]"
+
+// redactText replaces the secrets found by the redaction rules with [REDACTED].
+func redactText(text string) string {
+	for _, rule := range redactionRules {
+		text = replaceSecrets(text, rule.Regex, func(secret string) string {
+			return redactedText
+		})
+	}
+	return text
+}
+
+// replaceSecrets replaces each match of the regex, or its "secret" group, with the result of replace.
+func replaceSecrets(text string, regex *regexp.Regexp, replace func(secret string) string) string {
+	var groups []int
+	for i, name := range regex.SubexpNames() {
+		if name == "secret" {
+			groups = append(groups, i)
+		}
+	}
+	var result strings.Builder
+	last := 0
+	for _, match := range regex.FindAllStringSubmatchIndex(text, -1) {
+		start, end := match[0], match[1]
+		for _, group := range groups {
+			start, end = match[2*group], match[2*group+1]
+			if start >= 0 {
+				break
+			}
+		}
+		if start < 0 || start < last {
+			continue
+		}
+		secret := text[start:end]
+		if secret == redactedText {
+			continue
+		}
+		result.WriteString(text[last:start])
+		result.WriteString(replace(secret))
+		last = end
+	}
+	result.WriteString(text[last:])
+	return result.String()
+}
+
+// Redactor replaces secrets in outgoing messages with placeholders such as
+// REDACTED_AWS_ACCESS_KEY_1, and restores them in the model's response. The same secret always
+// gets the same placeholder, so that the model can refer to it.
+type Redactor struct {
+	rules        []redactionRule
+	placeholders map[string]string
+	secrets      map[string]string
+	counts       map[string]int
+}
+
+var placeholderNameRegex = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// newRedactor uses the built-in rules and the redact_patterns from the config.
+func newRedactor(config Config) *Redactor {
+	redactor := &Redactor{
+		rules:        append([]redactionRule{}, redactionRules...),
+		placeholders: map[string]string{},
+		secrets:      map[string]string{},
+		counts:       map[string]int{},
+	}
+	for _, pattern := range append(append([]RedactPattern{}, loadPolicy().RedactPatterns...), config.RedactPatterns...) {
+		regex, err := regexp.Compile(pattern.Regex)
+		if err != nil {
+			log.Fatalf("Error in redact pattern %s: %v", pattern.Name, err)
+		}
+		redactor.rules = append(redactor.rules, redactionRule{Name: firstNonEmpty(pattern.Name, "custom"), Regex: regex})
+	}
+	return redactor
+}
+
+// Redact replaces the secrets in text with placeholders.
+func (r *Redactor) Redact(text string) string {
+	for _, rule := range r.rules {
+		text = replaceSecrets(text, rule.Regex, func(secret string) string {
+			if _, isPlaceholder := r.secrets[secret]; isPlaceholder {
+				return secret
+			}
+			if placeholder, ok := r.placeholders[secret]; ok {
+				return placeholder
+			}
+			r.counts[rule.Name]++
+			name := strings.Trim(placeholderNameRegex.ReplaceAllString(strings.ToUpper(rule.Name), "_"), "_")
+			placeholder := fmt.Sprintf("REDACTED_%s_%d", name, r.counts[rule.Name])
+			r.placeholders[secret] = placeholder
+			r.secrets[placeholder] = secret
+			return placeholder
+		})
+	}
+	return text
+}
+
+// RedactMessages redacts the content of each message.
+func (r *Redactor) RedactMessages(messages []Message) []Message {
+	redacted := make([]Message, len(messages))
+	for i, message := range messages {
+		message.Content = r.Redact(message.Content)
+		redacted[i] = message
+	}
+	return redacted
+}
+
+// Restore puts the secrets back in place of their placeholders.
+func (r *Redactor) Restore(text string) string {
+	placeholders := make([]string, 0, len(r.secrets))
+	for placeholder := range r.secrets {
+		placeholders = append(placeholders, placeholder)
+	}
+	// Longer placeholders first, so that _1 doesn't replace the start of _10.
+	sort.Slice(placeholders, func(i, j int) bool { return len(placeholders[i]) > len(placeholders[j]) })
+	for _, placeholder := range placeholders {
+		text = strings.ReplaceAll(text, placeholder, r.secrets[placeholder])
+	}
+	return text
+}
+
+// RestoreAll restores the placeholders in each of the texts.
+func (r *Redactor) RestoreAll(texts []string) []string {
+	restored := make([]string, len(texts))
+	for i, text := range texts {
+		restored[i] = r.Restore(text)
+	}
+	return restored
+}
+
+var placeholderTailRegex = regexp.MustCompile(`^REDACTED_[A-Z0-9_]*$`)
+
+// RestoreChunk restores the placeholders in streamed text. A placeholder may be split over
+// chunks, so text at the end that could be the start of one is held back: pending is the text
+// not printed yet, and rest is the part to prepend to the next chunk.
+func (r *Redactor) RestoreChunk(pending string) (printable string, rest string) {
+	if len(r.secrets) == 0 {
+		return pending, ""
+	}
+	for i := strings.LastIndexByte(pending, 'R'); i >= 0 && len(pending)-i < 64; i = strings.LastIndexByte(pending[:i], 'R') {
+		tail := pending[i:]
+		if strings.HasPrefix("REDACTED_", tail) || placeholderTailRegex.MatchString(tail) {
+			return r.Restore(pending[:i]), tail
+		}
+	}
+	return r.Restore(pending), ""
+}
+
+// Summary lists the number of redacted secrets per kind, e.g. "AWS access key (1), password (2)".
+// It is empty when nothing was redacted.
+func (r *Redactor) Summary() string {
+	names := make([]string, 0, len(r.counts))
+	for name := range r.counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s (%d)", name, r.counts[name])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// PrintSummary tells the user what was redacted, if anything.
+func (r *Redactor) PrintSummary() {
+	if summary := r.Summary(); summary != "" {
+		color.New(color.Faint).Fprintf(os.Stderr, "Redacted before sending: %s\n", summary)
+	}
+}
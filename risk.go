@@ -0,0 +1,678 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+type RiskLevel int
+
+const (
+	RiskLow RiskLevel = iota
+	RiskMedium
+	RiskHigh
+)
+
+var riskLevelNames = []string{"low", "medium", "high"}
+
+func (l RiskLevel) String() string {
+	return riskLevelNames[l]
+}
+
+// parseRiskLevel returns the level with the given name, or defaultLevel when name is empty.
+func parseRiskLevel(name string, defaultLevel RiskLevel) RiskLevel {
+	if name == "" {
+		return defaultLevel
+	}
+	for level, levelName := range riskLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return RiskLevel(level)
+		}
+	}
+	log.Fatalf("Unknown risk level %q, use one of: %s", name, strings.Join(riskLevelNames, ", "))
+	return defaultLevel
+}
+
+// Risk is the outcome of classifying a command: the highest level found, and why.
+type Risk struct {
+	Level   RiskLevel
+	Reasons []string
+}
+
+func (r *Risk) add(level RiskLevel, reason string) {
+	if level > r.Level {
+		r.Level = level
+	}
+	if !contains(r.Reasons, reason) {
+		r.Reasons = append(r.Reasons, reason)
+	}
+}
+
+// riskSegment is a simple command: its words, and the words of the command piped into it.
+type riskSegment struct {
+	Words     []string
+	PipedFrom []string
+}
+
+var (
+	sqlDropRegex     = regexp.MustCompile(`(?i)\b(DROP\s+(DATABASE|TABLE|SCHEMA)|TRUNCATE\s+TABLE)\b`)
+	deviceWriteRegex = regexp.MustCompile(`>\s*/dev/(sd|hd|vd|xvd|nvme|mmcblk|disk)`)
+	forkBombRegex    = regexp.MustCompile(`:\s*\(\s*\)\s*\{\s*:\s*\|\s*:\s*&\s*\}`)
+	// downloadRunRegex matches scripts run straight from a download, as in bash <(curl ...) or
+	// sh -c "$(curl ...)".
+	downloadRunRegex = regexp.MustCompile(`(?:^|[\s;&|(])(sh|bash|zsh|dash|ksh|source|\.)\s+(?:-\S+\s+)*(?:<\(|"?\$\()\s*(curl|wget)\b`)
+	systemPathRegex  = regexp.MustCompile(`^(/|/\*|/(bin|boot|dev|etc|lib|lib64|opt|proc|sbin|sys|usr|var)(/.*)?|~|~/|~/\*|\$HOME/?|[A-Za-z]:\\?)$`)
+	// shellKeywords can precede a command, as in "for f in *; do rm $f; done".
+	shellKeywords     = []string{"do", "then", "else", "elif", "if", "while", "until", "!", "{", "}"}
+	shellInterpreters = []string{"sh", "bash", "zsh", "dash", "ksh", "fish", "python", "python3", "perl", "ruby", "node", "iex", "Invoke-Expression"}
+	// scriptShells run the script given with -c, or -Command for PowerShell.
+	scriptShells = []string{"sh", "bash", "zsh", "dash", "ksh", "fish", "pwsh", "powershell"}
+)
+
+const (
+	// xargsTarget stands for the arguments that xargs reads from its input.
+	xargsTarget = "the paths piped into xargs"
+	// maxRiskDepth limits how deeply nested scripts, such as sh -c "bash -c ...", are classified.
+	maxRiskDepth = 8
+)
+
+// classifyRisk parses the command and looks for destructive patterns. Scripts run with sh -c or
+// eval, command and process substitutions, and the commands run by xargs and find -exec are
+// classified too.
+func classifyRisk(command string, workingDirectory string) Risk {
+	risk := Risk{}
+	classifyCommand(command, workingDirectory, &risk, 0)
+	return risk
+}
+
+func classifyCommand(command string, workingDirectory string, risk *Risk, depth int) {
+	if depth > maxRiskDepth {
+		return
+	}
+	if sqlDropRegex.MatchString(command) {
+		risk.add(RiskHigh, "drops or truncates database objects")
+	}
+	if deviceWriteRegex.MatchString(command) {
+		risk.add(RiskHigh, "writes directly to a disk device")
+	}
+	if forkBombRegex.MatchString(command) {
+		risk.add(RiskHigh, "is a fork bomb")
+	}
+	if match := downloadRunRegex.FindStringSubmatch(command); match != nil {
+		risk.add(RiskHigh, fmt.Sprintf("runs a script downloaded with %s in %s", match[2], match[1]))
+	}
+	for _, substitution := range commandSubstitutions(command) {
+		classifyCommand(substitution, workingDirectory, risk, depth+1)
+	}
+
+	// cd changes the directory that relative paths in the following commands are in.
+	directory := workingDirectory
+	for _, segment := range splitCommand(command) {
+		directory = classifySegment(segment, workingDirectory, directory, risk, depth)
+	}
+}
+
+// classifySegment classifies a simple command that runs in directory, which is empty when it is
+// unknown. It returns the directory for the next command.
+func classifySegment(segment riskSegment, workingDirectory string, directory string, risk *Risk, depth int) string {
+	words := segment.Words
+	// Look through prefixes that run the actual command.
+	for len(words) > 0 {
+		switch {
+		case words[0] == "sudo" || words[0] == "doas":
+			risk.add(RiskMedium, "runs as root with "+words[0])
+			words = skipFlags(words[1:])
+			continue
+		case contains(shellKeywords, words[0]) || words[0] == "env" || words[0] == "nohup" || words[0] == "time" || words[0] == "command" || words[0] == "exec" || strings.Contains(words[0], "=") && !strings.HasPrefix(words[0], "-"):
+			words = words[1:]
+			continue
+		}
+		break
+	}
+	if len(words) == 0 {
+		return directory
+	}
+	name := filepath.Base(words[0])
+	args := words[1:]
+	if strings.HasPrefix(name, "mkfs.") {
+		name = "mkfs"
+	}
+
+	if len(segment.PipedFrom) > 0 && contains(shellInterpreters, name) {
+		source := filepath.Base(segment.PipedFrom[0])
+		if source == "curl" || source == "wget" || source == "iwr" || source == "Invoke-WebRequest" || source == "irm" || source == "Invoke-RestMethod" {
+			risk.add(RiskHigh, fmt.Sprintf("pipes a download from %s into %s", source, name))
+		}
+	}
+	if contains(scriptShells, name) {
+		if script, ok := shellScript(name, args); ok {
+			classifyCommand(script, workingDirectory, risk, depth+1)
+			return directory
+		}
+	}
+
+	switch strings.ToLower(name) {
+	case "cd", "pushd", "set-location":
+		return changeDirectory(nonFlags(args), directory)
+	case "eval":
+		classifyCommand(strings.Join(args, " "), workingDirectory, risk, depth+1)
+	case "xargs":
+		command := xargsCommand(args)
+		if len(command) > 0 {
+			classifySegment(riskSegment{Words: command}, workingDirectory, directory, risk, depth+1)
+		}
+	case "rm", "remove-item", "del", "rd", "rmdir":
+		if hasFlag(args, "r", "R", "-recursive", "Recurse") {
+			for _, target := range nonFlags(args) {
+				outside, description := describeTarget(target, workingDirectory, directory)
+				if outside {
+					risk.add(RiskHigh, "recursively deletes "+description)
+				} else {
+					risk.add(RiskMedium, "recursively deletes "+description)
+				}
+			}
+		}
+	case "dd":
+		for _, arg := range args {
+			if strings.HasPrefix(arg, "of=/dev/") && arg != "of=/dev/null" {
+				risk.add(RiskHigh, "overwrites the device "+strings.TrimPrefix(arg, "of="))
+			}
+		}
+	case "mkfs", "format-volume", "fdisk", "parted", "wipefs", "diskpart":
+		risk.add(RiskHigh, "formats or repartitions a disk")
+	case "find":
+		classifyFind(args, workingDirectory, directory, risk, depth)
+	case "shred":
+		risk.add(RiskHigh, "irrecoverably overwrites files")
+	case "chmod", "chown", "chgrp":
+		recursive := hasFlag(args, "R", "-recursive")
+		for _, target := range nonFlags(args) {
+			if recursive && systemPathRegex.MatchString(target) {
+				risk.add(RiskHigh, fmt.Sprintf("recursively changes the permissions of %s", target))
+			}
+		}
+		if name == "chmod" && (contains(args, "777") || contains(args, "a+rwx")) {
+			risk.add(RiskMedium, "makes files writable by everyone")
+		}
+	case "git":
+		subcommand := nonFlags(args)
+		if len(subcommand) == 0 {
+			break
+		}
+		switch subcommand[0] {
+		case "push":
+			if hasFlag(args, "f", "-force") {
+				risk.add(RiskHigh, "force pushes, which can overwrite remote history")
+			} else if hasFlag(args, "-force-with-lease") || hasPrefixArg(args, "+") {
+				risk.add(RiskMedium, "force pushes, which can overwrite remote history")
+			}
+			if hasFlag(args, "-delete") || hasPrefixArg(args, ":") {
+				risk.add(RiskMedium, "deletes a remote branch")
+			}
+		case "reset":
+			if contains(args, "--hard") {
+				risk.add(RiskMedium, "discards uncommitted changes")
+			}
+		case "clean":
+			if hasFlag(args, "f", "-force") {
+				risk.add(RiskMedium, "deletes untracked files")
+			}
+		}
+	case "kubectl", "oc":
+		subcommand := nonFlags(args)
+		if len(subcommand) > 0 && subcommand[0] == "delete" {
+			if len(subcommand) > 1 && (subcommand[1] == "namespace" || subcommand[1] == "ns" || strings.HasPrefix(subcommand[1], "namespace/") || strings.HasPrefix(subcommand[1], "ns/")) {
+				risk.add(RiskHigh, "deletes a Kubernetes namespace and everything in it")
+			} else if contains(args, "--all") || contains(args, "-A") || contains(args, "--all-namespaces") {
+				risk.add(RiskHigh, "deletes all matching Kubernetes resources")
+			} else {
+				risk.add(RiskMedium, "deletes Kubernetes resources")
+			}
+		}
+	case "shutdown", "reboot", "halt", "poweroff", "stop-computer", "restart-computer":
+		risk.add(RiskMedium, "shuts down or restarts the machine")
+	}
+	return directory
+}
+
+// classifyFind classifies find -delete, and the commands of -exec and -ok, whose {} stands for
+// the files found under the starting points.
+func classifyFind(args []string, workingDirectory string, directory string, risk *Risk, depth int) {
+	var starts []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") || arg == "!" {
+			break
+		}
+		starts = append(starts, arg)
+	}
+	if len(starts) == 0 {
+		starts = []string{"."}
+	}
+
+	// {} stands for the files found under a starting point, rather than the starting point itself.
+	found := func(start string) string {
+		if strings.HasPrefix(start, "~") || strings.HasPrefix(start, "$") {
+			return strings.TrimSuffix(start, "/") + "/*"
+		}
+		return filepath.Join(start, "*")
+	}
+	if contains(args, "-delete") {
+		for _, start := range starts {
+			if outside, _ := describeTarget(found(start), workingDirectory, directory); outside {
+				risk.add(RiskHigh, "deletes the files it finds in "+start+", outside the current directory")
+			} else {
+				risk.add(RiskMedium, "deletes the files it finds")
+			}
+		}
+	}
+	for i := 0; i < len(args); i++ {
+		if args[i] != "-exec" && args[i] != "-execdir" && args[i] != "-ok" && args[i] != "-okdir" {
+			continue
+		}
+		var command []string
+		for i++; i < len(args) && args[i] != ";" && args[i] != "+"; i++ {
+			command = append(command, args[i])
+		}
+		for _, start := range starts {
+			words := make([]string, len(command))
+			for j, word := range command {
+				words[j] = strings.ReplaceAll(word, "{}", found(start))
+			}
+			classifySegment(riskSegment{Words: words}, workingDirectory, directory, risk, depth+1)
+		}
+	}
+}
+
+// describeTarget tells whether a path, relative to directory, is outside the working directory or
+// is the working directory itself, and describes it for the reasons of a risk.
+func describeTarget(target string, workingDirectory string, directory string) (bool, string) {
+	if target == xargsTarget {
+		return true, target
+	}
+	if systemPathRegex.MatchString(target) || outsideDirectory(target, workingDirectory) && !isRelativePath(target) {
+		return true, target + ", outside the current directory"
+	}
+	if directory == "" {
+		return true, target + " in a directory changed to with cd"
+	}
+	path := filepath.Clean(filepath.Join(directory, target))
+	description := target
+	if directory != workingDirectory {
+		description = path
+	}
+	switch {
+	case path == filepath.Clean(workingDirectory):
+		return true, "the current directory"
+	case systemPathRegex.MatchString(path) || outsideDirectory(path, workingDirectory):
+		return true, description + ", outside the current directory"
+	}
+	return false, description
+}
+
+// isRelativePath tells whether a path is relative to the current directory, rather than to the
+// root, the home directory or a variable.
+func isRelativePath(path string) bool {
+	return !filepath.IsAbs(path) && !strings.HasPrefix(path, "/") && !strings.HasPrefix(path, "~") && !strings.HasPrefix(path, "$") && !strings.HasPrefix(path, "%") && !systemPathRegex.MatchString(path)
+}
+
+// changeDirectory returns the directory that cd changes to, or an empty string when it can't be
+// known, as with cd $DIR.
+func changeDirectory(args []string, directory string) string {
+	if len(args) == 0 || args[0] == "~" {
+		return homeDir
+	}
+	target := args[0]
+	switch {
+	case strings.HasPrefix(target, "~/"):
+		return filepath.Join(homeDir, target[2:])
+	case strings.ContainsAny(target, "$`*?") || target == "-":
+		return ""
+	case filepath.IsAbs(target) || strings.HasPrefix(target, "/"):
+		return filepath.Clean(target)
+	case directory == "":
+		return ""
+	}
+	return filepath.Join(directory, target)
+}
+
+// shellScript returns the script that a shell runs with -c, as in sh -c "rm -rf /".
+func shellScript(name string, args []string) (string, bool) {
+	for i, arg := range args {
+		isCommandFlag := strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.Contains(arg[1:], "c")
+		if name == "pwsh" || name == "powershell" {
+			isCommandFlag = strings.EqualFold(arg, "-c") || strings.EqualFold(arg, "-Command")
+		}
+		if isCommandFlag && i+1 < len(args) {
+			return args[i+1], true
+		}
+		if !strings.HasPrefix(arg, "-") {
+			break
+		}
+	}
+	return "", false
+}
+
+// xargsFlagsWithValues are the xargs flags that take a separate value.
+var xargsFlagsWithValues = []string{"-I", "-n", "-P", "-L", "-d", "-s", "-E", "-a", "--max-args", "--max-procs", "--delimiter", "--arg-file", "--replace"}
+
+// xargsCommand returns the command that xargs runs, with its input as the last argument, or in
+// place of the replacement string of -I.
+func xargsCommand(args []string) []string {
+	replace := ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			command := append([]string{}, args[i:]...)
+			if replace == "" {
+				return append(command, xargsTarget)
+			}
+			for j, word := range command {
+				command[j] = strings.ReplaceAll(word, replace, xargsTarget)
+			}
+			return command
+		}
+		if contains(xargsFlagsWithValues, arg) && i+1 < len(args) {
+			i++
+			if arg == "-I" || arg == "--replace" {
+				replace = args[i]
+			}
+		} else if strings.HasPrefix(arg, "-I") && len(arg) > 2 {
+			replace = arg[2:]
+		} else if arg == "-i" || strings.HasPrefix(arg, "--replace=") {
+			replace = firstNonEmpty(strings.TrimPrefix(strings.TrimPrefix(arg, "--replace="), "-i"), "{}")
+		}
+	}
+	return nil
+}
+
+// commandSubstitutions returns the commands in $(...), backticks and the process substitutions
+// <(...) and >(...), which run even when they are arguments of a harmless command.
+func commandSubstitutions(command string) []string {
+	var substitutions []string
+	runes := []rune(command)
+	singleQuoted, doubleQuoted := false, false
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case singleQuoted:
+			singleQuoted = r != '\''
+		case r == '"':
+			doubleQuoted = !doubleQuoted
+		case r == '\'' && !doubleQuoted:
+			singleQuoted = true
+		case r == '\\':
+			i++
+		case r == '`':
+			end := i + 1
+			for end < len(runes) && runes[end] != '`' {
+				if runes[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end < len(runes) {
+				substitutions = append(substitutions, string(runes[i+1:end]))
+			}
+			i = end
+		case (r == '$' || r == '<' || r == '>') && i+1 < len(runes) && runes[i+1] == '(':
+			if r == '$' && i+2 < len(runes) && runes[i+2] == '(' {
+				// $(( is arithmetic.
+				continue
+			}
+			depth := 0
+			for end := i + 1; end < len(runes); end++ {
+				if runes[end] == '(' {
+					depth++
+				} else if runes[end] == ')' {
+					depth--
+					if depth == 0 {
+						substitutions = append(substitutions, string(runes[i+2:end]))
+						i = end
+						break
+					}
+				}
+			}
+		}
+	}
+	return substitutions
+}
+
+// splitCommand splits a command line into simple commands on ;, &&, ||, |, & and newlines,
+// honoring quotes and backslash escapes. Redirections such as >out.txt and 2>&1 are split into
+// words of their own.
+func splitCommand(command string) []riskSegment {
+	var segments []riskSegment
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	piped := false
+
+	endWord := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	endSegment := func(pipe bool) {
+		endWord()
+		if len(words) > 0 {
+			segment := riskSegment{Words: words}
+			if piped && len(segments) > 0 {
+				segment.PipedFrom = segments[len(segments)-1].Words
+			}
+			segments = append(segments, segment)
+		}
+		words = nil
+		piped = pipe
+	}
+
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else if r == '\\' && quote == '"' && i+1 < len(runes) {
+				i++
+				word.WriteRune(runes[i])
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '\\' && i+1 < len(runes):
+			i++
+			if runes[i] != '\n' {
+				word.WriteRune(runes[i])
+				inWord = true
+			}
+		case r == '#' && !inWord:
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			endSegment(false)
+		case r == '|':
+			if i+1 < len(runes) && runes[i+1] == '|' {
+				i++
+				endSegment(false)
+			} else {
+				endSegment(true)
+			}
+		case r == '&' && i+1 < len(runes) && runes[i+1] == '>':
+			// &> redirects both output streams.
+			endWord()
+			word.WriteRune(r)
+			inWord = true
+		case r == '>' && !(i+1 < len(runes) && runes[i+1] == '('):
+			// A redirection is a word of its own, even when written as a>b. A file descriptor
+			// before it, as in 2>err.log, and a target file descriptor, as in 2>&1, belong to it.
+			if !isRedirectionPrefix(word.String()) {
+				endWord()
+			}
+			word.WriteRune(r)
+			for i+1 < len(runes) && (runes[i+1] == '>' || runes[i+1] == '|') {
+				i++
+				word.WriteRune(runes[i])
+			}
+			if i+1 < len(runes) && runes[i+1] == '&' {
+				for i++; i < len(runes) && (runes[i] == '&' || runes[i] == '-' || runes[i] >= '0' && runes[i] <= '9'); i++ {
+					word.WriteRune(runes[i])
+				}
+				i--
+			}
+			inWord = true
+			endWord()
+		case r == ';' || r == '&' || r == '\n':
+			if r == '&' && i+1 < len(runes) && runes[i+1] == '&' {
+				i++
+			}
+			endSegment(false)
+		case r == ' ' || r == '\t' || r == '(' || r == ')':
+			endWord()
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	endSegment(false)
+	return segments
+}
+
+// isRedirectionPrefix tells whether a word is the file descriptor before a redirection.
+func isRedirectionPrefix(word string) bool {
+	return word != "" && strings.Trim(word, "0123456789") == "" || word == "&"
+}
+
+// hasFlag tells whether args contain one of the flags. Single letters also match within
+// combined short flags such as -rf; names starting with "-" are long flags, and other names
+// are PowerShell parameters.
+func hasFlag(args []string, flags ...string) bool {
+	for _, arg := range args {
+		for _, flag := range flags {
+			switch {
+			case len(flag) == 1:
+				if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.Contains(arg[1:], flag) {
+					return true
+				}
+			case strings.HasPrefix(flag, "-"):
+				if arg == "-"+flag || strings.HasPrefix(arg, "-"+flag+"=") {
+					return true
+				}
+			default:
+				if strings.EqualFold(arg, "-"+flag) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func hasPrefixArg(args []string, prefix string) bool {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, prefix) && len(arg) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func nonFlags(args []string) []string {
+	var result []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			result = append(result, arg)
+		}
+	}
+	return result
+}
+
+// skipFlags drops the flags of a prefix command such as sudo.
+func skipFlags(words []string) []string {
+	for len(words) > 0 && strings.HasPrefix(words[0], "-") {
+		words = words[1:]
+	}
+	return words
+}
+
+// outsideDirectory tells whether a path escapes the working directory. Paths with variables
+// at the top level, such as $HOME, count as outside; the working directory itself does not.
+func outsideDirectory(target string, workingDirectory string) bool {
+	if target == "~" || strings.HasPrefix(target, "~/") || strings.HasPrefix(target, "$") || strings.HasPrefix(target, "%") {
+		return true
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(workingDirectory, target)
+	}
+	relative, err := filepath.Rel(workingDirectory, filepath.Clean(target))
+	return err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+var riskColors = map[RiskLevel]*color.Color{
+	RiskMedium: color.New(color.FgYellow),
+	RiskHigh:   color.New(color.FgRed, color.Bold),
+}
+
+// printRisk shows the risk of a command, unless it is low.
+func printRisk(risk Risk) {
+	if risk.Level == RiskLow {
+		return
+	}
+	level := risk.Level.String()
+	riskColors[risk.Level].Printf("⚠ %s risk: %s\n", strings.ToUpper(level[:1])+level[1:], strings.Join(risk.Reasons, "; "))
+}
+
+// checkCommandRisk shows the risk of a command. Before executing it, the user has to confirm
+// commands at or above the confirm_risk level. It returns false when the user declined.
+func checkCommandRisk(command string, execute bool, config Config) (Risk, bool) {
+	workingDirectory, _ := os.Getwd()
+	risk := classifyRisk(command, workingDirectory)
+	printRisk(risk)
+	if !execute || risk.Level < parseRiskLevel(config.ConfirmRisk, RiskHigh) {
+		return risk, true
+	}
+	return risk, confirmRisk(risk)
+}
+
+// confirmRisk asks the user to type "yes" before a risky command is executed.
+func confirmRisk(risk Risk) bool {
+	answer, err := readTerminalLine("Type yes to execute this command: ")
+	if err != nil {
+		color.Red("Not executing a %s risk command without a terminal to confirm it.", risk.Level)
+		return false
+	}
+	return answer == "yes"
+}
+
+// readTerminalLine asks a question on the terminal. It reads from the terminal rather than
+// stdin, as stdin may have been piped into ai.
+func readTerminalLine(prompt string) (string, error) {
+	input := os.Stdin
+	if !isTerm(os.Stdin.Fd()) {
+		ttyPath := "/dev/tty"
+		if runtime.GOOS == "windows" {
+			ttyPath = "CONIN$"
+		}
+		tty, err := os.Open(ttyPath)
+		if err != nil {
+			return "", err
+		}
+		defer tty.Close()
+		input = tty
+	}
+	fmt.Print(prompt)
+	answer, _ := bufio.NewReader(input).ReadString('\n')
+	return strings.TrimSpace(answer), nil
+}
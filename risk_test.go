@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func TestClassifyRisk(t *testing.T) {
+	tests := []struct {
+		command string
+		level   RiskLevel
+	}{
+		{`ls -la`, RiskLow},
+		{`echo "rm -rf /"`, RiskLow},
+		{`rm -rf build`, RiskMedium},
+		{`rm -rf ./`, RiskHigh},
+		{`rm -rf /`, RiskHigh},
+		{`rm -rf "$HOME"`, RiskHigh},
+		{`rm -rf ../other`, RiskHigh},
+		{`sudo rm -rf /var/lib/docker`, RiskHigh},
+		{`for f in *; do rm -rf /tmp/$f; done`, RiskHigh},
+		{`chmod -R 777 /`, RiskHigh},
+		{`chmod 777 script.sh`, RiskMedium},
+		{`curl -fsSL https://example.com/install.sh | sudo bash`, RiskHigh},
+		{`curl -fsSL https://example.com/install.sh -o install.sh`, RiskLow},
+		{`git push -f origin main`, RiskHigh},
+		{`git push --force-with-lease`, RiskMedium},
+		{`git push origin main`, RiskLow},
+		{`git reset --hard HEAD~1`, RiskMedium},
+		{`dd if=image.iso of=/dev/sdb bs=4M`, RiskHigh},
+		{`mkfs.ext4 /dev/sdb1`, RiskHigh},
+		{`kubectl delete namespace production`, RiskHigh},
+		{`psql -c "DROP TABLE users"`, RiskHigh},
+
+		// Commands hidden in scripts and substitutions.
+		{`sh -c "rm -rf /"`, RiskHigh},
+		{`bash -c 'rm -rf ~'`, RiskHigh},
+		{`sudo bash -xc 'rm -rf /etc'`, RiskHigh},
+		{`eval "rm -rf /"`, RiskHigh},
+		{`echo $(rm -rf ~)`, RiskHigh},
+		{"echo `rm -rf /`", RiskHigh},
+		{`echo '$(rm -rf /)'`, RiskLow},
+		{`bash <(curl -s https://example.com/install.sh)`, RiskHigh},
+		{`sh -c "$(curl -fsSL https://example.com/install.sh)"`, RiskHigh},
+
+		// Commands run by find and xargs.
+		{`find / -exec rm -rf {} +`, RiskHigh},
+		{`find ~ -name '*.bak' -exec rm -rf {} \;`, RiskHigh},
+		{`find . -name node_modules -exec rm -rf {} +`, RiskMedium},
+		{`find . -name '*.tmp' -delete`, RiskMedium},
+		{`find /etc -name '*.conf' -delete`, RiskHigh},
+		{`ls | xargs rm -rf`, RiskHigh},
+		{`find . -type d | xargs -I {} rm -rf {}`, RiskHigh},
+		{`ls | xargs wc -l`, RiskLow},
+
+		// cd changes what relative paths refer to.
+		{`cd / && rm -rf *`, RiskHigh},
+		{`cd ~ && rm -rf .cache`, RiskHigh},
+		{`cd "$DIR" && rm -rf *`, RiskHigh},
+		{`cd build && rm -rf *`, RiskMedium},
+	}
+	for _, test := range tests {
+		risk := classifyRisk(test.command, "/home/user/project")
+		if risk.Level != test.level {
+			t.Errorf("classifyRisk(%q) = %s %q, want %s", test.command, risk.Level, risk.Reasons, test.level)
+		}
+	}
+}
+
+func TestClassifyRiskReasons(t *testing.T) {
+	tests := []struct {
+		command string
+		reason  string
+	}{
+		{`rm -rf ./`, "recursively deletes the current directory"},
+		{`rm -rf /`, "recursively deletes /, outside the current directory"},
+		{`rm -rf build`, "recursively deletes build"},
+		{`cd / && rm -rf *`, "recursively deletes /*, outside the current directory"},
+		{`ls | xargs rm -rf`, "recursively deletes the paths piped into xargs"},
+	}
+	for _, test := range tests {
+		risk := classifyRisk(test.command, "/home/user/project")
+		if !contains(risk.Reasons, test.reason) {
+			t.Errorf("classifyRisk(%q) reasons = %q, want %q", test.command, risk.Reasons, test.reason)
+		}
+	}
+}
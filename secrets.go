@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	secretRefEnv       = "env:"
+	secretRefCommand   = "command:"
+	secretRefEncrypted = "encrypted:"
+)
+
+var secretsFilePath = filepath.Join(homeDir, ".ai-secrets")
+
+// SecretStore looks up secrets by name. What a name means depends on the backend:
+// a variable name, a command line, or a key in the encrypted file.
+type SecretStore interface {
+	Get(name string) (string, error)
+}
+
+func isSecretReference(value string) bool {
+	for _, prefix := range []string{secretRefEnv, secretRefCommand, secretRefEncrypted} {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveSecret turns a secret reference from the config file into the secret itself.
+// Values without a known prefix are treated as legacy plain-text secrets.
+func resolveSecret(reference string) (string, error) {
+	var store SecretStore
+	var name string
+	switch {
+	case strings.HasPrefix(reference, secretRefEnv):
+		store, name = envSecretStore{}, strings.TrimPrefix(reference, secretRefEnv)
+	case strings.HasPrefix(reference, secretRefCommand):
+		store, name = commandSecretStore{}, strings.TrimPrefix(reference, secretRefCommand)
+	case strings.HasPrefix(reference, secretRefEncrypted):
+		store, name = newEncryptedFileStore(secretsFilePath), strings.TrimPrefix(reference, secretRefEncrypted)
+	default:
+		fmt.Fprintf(os.Stderr, "Warning: %s contains a plain-text secret. Run `ai --init` to move it into the encrypted store.\n", configFilePath)
+		return reference, nil
+	}
+	return store.Get(strings.TrimSpace(name))
+}
+
+type envSecretStore struct{}
+
+func (envSecretStore) Get(name string) (string, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// commandSecretStore runs a helper such as `pass show openai` and uses the first line of its output.
+type commandSecretStore struct{}
+
+func (commandSecretStore) Get(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	// Helpers that prompt, such as pass asking for a GPG passphrase, read from the terminal. Stdin
+	// is not passed on: it holds the input piped into ai, which is read later.
+	ttyPath := "/dev/tty"
+	if runtime.GOOS == "windows" {
+		ttyPath = "CONIN$"
+	}
+	if tty, err := os.Open(ttyPath); err == nil {
+		defer tty.Close()
+		cmd.Stdin = tty
+	}
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "running secret command %q", command)
+	}
+	value := strings.TrimSpace(strings.SplitN(string(output), "\n", 2)[0])
+	if value == "" {
+		return "", fmt.Errorf("secret command %q returned no output", command)
+	}
+	return value, nil
+}
+
+// encryptedFile is the on-disk format of the encrypted secret store. The secrets are a JSON
+// map sealed with NaCl secretbox, using a key derived from the passphrase with scrypt.
+type encryptedFile struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	N       int    `json:"n"`
+	R       int    `json:"r"`
+	P       int    `json:"p"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+type encryptedFileStore struct {
+	path string
+}
+
+// The passphrase is asked at most once per run.
+var cachedPassphrase *string = nil
+
+func newEncryptedFileStore(path string) *encryptedFileStore {
+	return &encryptedFileStore{path: path}
+}
+
+func (s *encryptedFileStore) Get(name string) (string, error) {
+	secrets, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	value, ok := secrets[name]
+	if !ok {
+		return "", fmt.Errorf("secret %q not found in %s", name, s.path)
+	}
+	return value, nil
+}
+
+func (s *encryptedFileStore) Set(name, value string) error {
+	secrets := map[string]string{}
+	if _, err := os.Stat(s.path); err == nil {
+		secrets, err = s.load()
+		if err != nil {
+			return err
+		}
+	} else if cachedPassphrase == nil {
+		passphrase, err := askNewPassphrase()
+		if err != nil {
+			return err
+		}
+		cachedPassphrase = &passphrase
+	}
+	secrets[name] = value
+	return s.save(secrets)
+}
+
+func (s *encryptedFileStore) load() (map[string]string, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading secret store")
+	}
+	var file encryptedFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing secret store %s", s.path)
+	}
+	if file.Version != 1 || file.KDF != "scrypt" || len(file.Nonce) != 24 {
+		return nil, fmt.Errorf("unsupported secret store format in %s", s.path)
+	}
+
+	passphrase, err := getPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(passphrase, file.Salt, file.N, file.R, file.P)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	copy(nonce[:], file.Nonce)
+	plaintext, ok := secretbox.Open(nil, file.Data, &nonce, key)
+	if !ok {
+		cachedPassphrase = nil
+		return nil, errors.New("wrong passphrase or corrupted secret store")
+	}
+
+	secrets := map[string]string{}
+	err = json.Unmarshal(plaintext, &secrets)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding secret store")
+	}
+	return secrets, nil
+}
+
+func (s *encryptedFileStore) save(secrets map[string]string) error {
+	passphrase, err := getPassphrase()
+	if err != nil {
+		return err
+	}
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	file := encryptedFile{Version: 1, KDF: "scrypt", N: 1 << 15, R: 8, P: 1, Salt: make([]byte, 16), Nonce: make([]byte, 24)}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return err
+	}
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return err
+	}
+	key, err := deriveKey(passphrase, file.Salt, file.N, file.R, file.P)
+	if err != nil {
+		return err
+	}
+	var nonce [24]byte
+	copy(nonce[:], file.Nonce)
+	file.Data = secretbox.Seal(nil, plaintext, &nonce, key)
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(s.path, data, 0600)
+	if err != nil {
+		return errors.Wrap(err, "writing secret store")
+	}
+	return os.Chmod(s.path, 0600)
+}
+
+func deriveKey(passphrase string, salt []byte, n, r, p int) (*[32]byte, error) {
+	derived, err := scrypt.Key([]byte(passphrase), salt, n, r, p, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "deriving key")
+	}
+	var key [32]byte
+	copy(key[:], derived)
+	return &key, nil
+}
+
+// getPassphrase returns the passphrase for the encrypted store, from AI_SECRETS_PASSPHRASE or the terminal.
+func getPassphrase() (string, error) {
+	if cachedPassphrase != nil {
+		return *cachedPassphrase, nil
+	}
+	passphrase := os.Getenv("AI_SECRETS_PASSPHRASE")
+	if passphrase == "" {
+		var err error
+		passphrase, err = readPassword(fmt.Sprintf("Passphrase for %s: ", secretsFilePath))
+		if err != nil {
+			return "", err
+		}
+	}
+	cachedPassphrase = &passphrase
+	return passphrase, nil
+}
+
+func askNewPassphrase() (string, error) {
+	if passphrase := os.Getenv("AI_SECRETS_PASSPHRASE"); passphrase != "" {
+		return passphrase, nil
+	}
+	passphrase, err := readPassword(fmt.Sprintf("New passphrase for %s: ", secretsFilePath))
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", errors.New("passphrase must not be empty")
+	}
+	confirmation, err := readPassword("Repeat passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase != confirmation {
+		return "", errors.New("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+// readPassword prompts on the terminal without echoing the input. It falls back to the
+// controlling terminal when stdin is a pipe.
+func readPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+
+	if isTerm(os.Stdin.Fd()) {
+		password, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		return string(password), err
+	}
+
+	ttyPath := "/dev/tty"
+	if runtime.GOOS == "windows" {
+		ttyPath = "CONIN$"
+	}
+	tty, err := os.Open(ttyPath)
+	if err != nil {
+		return "", errors.New("no terminal available to read the passphrase; set AI_SECRETS_PASSPHRASE")
+	}
+	defer tty.Close()
+	password, err := terminal.ReadPassword(int(tty.Fd()))
+	return string(password), err
+}
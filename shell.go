@@ -1,15 +1,17 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/shirou/gopsutil/process"
 )
 
 func getShell() string {
-	knownShells := []string{"bash", "sh", "zsh", "powershell", "cmd", "fish", "tcsh", "csh", "ksh", "dash"}
+	knownShells := []string{"bash", "sh", "zsh", "powershell", "pwsh", "cmd", "fish", "nu", "tcsh", "csh", "ksh", "dash", "ash"}
 
 	pid := os.Getppid()
 	for {
@@ -44,14 +46,67 @@ func getShell() string {
 
 var shellCache *string = nil
 
+// environmentCacheTTL applies to facts that are cached with a key that changes along with
+// them, such as the shell's binary path and modification time.
+const environmentCacheTTL = 30 * 24 * time.Hour
+
+// getShellCached detects the shell once per process, and across calls for as long as the
+// parent process is the same.
 func getShellCached() string {
 	if shellCache == nil {
-		shell := getShell()
+		shell := ""
+		key := parentProcessKey()
+		if key == "" || !readCache("shell", key, environmentCacheTTL, &shell) {
+			shell = getShell()
+			if key != "" {
+				writeCache("shell", key, shell)
+			}
+		}
 		shellCache = &shell
 	}
 	return *shellCache
 }
 
+// parentProcessKey identifies the parent process by its pid and start time, as pids are reused.
+func parentProcessKey() string {
+	parent, err := process.NewProcess(int32(os.Getppid()))
+	if err != nil {
+		return ""
+	}
+	created, err := parent.CreateTime()
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d@%d", parent.Pid, created)
+}
+
+func gatherShellVersion(request ContextRequest) (interface{}, error) {
+	return getShellVersionCached(request.Shell), nil
+}
+
+// getShellVersionCached only runs the shell when its binary changed since the last call.
+func getShellVersionCached(shell string) string {
+	path, err := exec.LookPath(shell)
+	if err != nil {
+		return getShellVersion(shell)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return getShellVersion(shell)
+	}
+	key := fmt.Sprintf("%s@%s/%d", path, info.ModTime().UTC().Format(time.RFC3339Nano), info.Size())
+
+	var version string
+	if readCache("shell-version-"+shell, key, environmentCacheTTL, &version) {
+		return version
+	}
+	version = getShellVersion(shell)
+	if !strings.HasPrefix(version, "Error ") {
+		writeCache("shell-version-"+shell, key, version)
+	}
+	return version
+}
+
 func getShellVersion(shell string) string {
 	if shell == "" {
 		return ""
@@ -59,7 +114,7 @@ func getShellVersion(shell string) string {
 
 	var versionOutput *string = nil
 	switch shell {
-	case "powershell":
+	case "powershell", "pwsh":
 		// read: $PSVersionTable.PSVersion
 		versionCmd := exec.Command(shell, "-Command", "$PSVersionTable.PSVersion")
 		versionCmdOutput, err := versionCmd.Output()
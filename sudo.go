@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"runtime"
+	"time"
+)
+
+const (
+	SudoRoot         = "root"
+	SudoPasswordless = "passwordless"
+	SudoPassword     = "password"
+	SudoNone         = "none"
+)
+
+// SudoAccess describes whether and how the user can run commands as root.
+type SudoAccess struct {
+	// Level is one of SudoRoot, SudoPasswordless, SudoPassword or SudoNone.
+	Level string
+	// Command is the command to elevate with, "sudo" or "doas". It is empty for root and none.
+	Command string
+}
+
+const (
+	sudoTimeout = 2 * time.Second
+	// sudoCacheTTL bounds how long a changed sudo setup that the cache key misses goes unnoticed,
+	// such as a changed group membership.
+	sudoCacheTTL = 24 * time.Hour
+)
+
+// sudoConfigFiles change what the user may run as root.
+var sudoConfigFiles = []string{"/etc/sudoers", "/etc/sudoers.d", "/etc/doas.conf"}
+
+// adminGroups are the groups that are allowed to use sudo or doas by default.
+var adminGroups = []string{"sudo", "wheel", "admin"}
+
+func gatherSudoAccess(request ContextRequest) (interface{}, error) {
+	return detectSudoAccessCached(), nil
+}
+
+// detectSudoAccessCached caches the result until the sudo or doas configuration changes, as
+// each failed check without a password is written to the auth log.
+func detectSudoAccessCached() SudoAccess {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		return detectSudoAccess()
+	}
+	key := fmt.Sprintf("uid %d", os.Getuid())
+	for _, path := range sudoConfigFiles {
+		if info, err := os.Stat(path); err == nil {
+			key += "|" + path + "@" + info.ModTime().String()
+		}
+	}
+	var access SudoAccess
+	if readCache("sudo", key, sudoCacheTTL, &access) {
+		return access
+	}
+	access = detectSudoAccess()
+	writeCache("sudo", key, access)
+	return access
+}
+
+func detectSudoAccess() SudoAccess {
+	if runtime.GOOS == "windows" {
+		// `net session` only succeeds in an elevated prompt.
+		if exec.Command("net", "session").Run() == nil {
+			return SudoAccess{Level: SudoRoot}
+		}
+		return SudoAccess{Level: SudoNone}
+	}
+
+	if os.Geteuid() == 0 {
+		return SudoAccess{Level: SudoRoot}
+	}
+
+	inAdminGroup := isInAdminGroup()
+	for _, command := range []string{"sudo", "doas"} {
+		if _, err := exec.LookPath(command); err != nil {
+			continue
+		}
+		if runsWithoutPassword(command) {
+			return SudoAccess{Level: SudoPasswordless, Command: command}
+		}
+		if inAdminGroup {
+			return SudoAccess{Level: SudoPassword, Command: command}
+		}
+	}
+	return SudoAccess{Level: SudoNone}
+}
+
+// runsWithoutPassword checks with `sudo -n -k true` (or doas -n) whether elevation works without a
+// password prompt. With -k, sudo ignores credentials cached by an earlier sudo, so that a user
+// who just entered their password doesn't look passwordless.
+func runsWithoutPassword(command string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), sudoTimeout)
+	defer cancel()
+	args := []string{"-n", "true"}
+	if command == "sudo" {
+		args = []string{"-n", "-k", "true"}
+	}
+	return exec.CommandContext(ctx, command, args...).Run() == nil
+}
+
+func isInAdminGroup() bool {
+	currentUser, err := user.Current()
+	if err != nil {
+		return false
+	}
+	groupIds, err := currentUser.GroupIds()
+	if err != nil {
+		return false
+	}
+	for _, groupId := range groupIds {
+		group, err := user.LookupGroupId(groupId)
+		if err == nil && contains(adminGroups, group.Name) {
+			return true
+		}
+	}
+	return false
+}
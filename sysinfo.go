@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/shirou/gopsutil/host"
+)
+
+// SystemInfo describes the machine the shell runs on.
+type SystemInfo struct {
+	OS           string
+	Distribution string
+	Kernel       string
+	Architecture string
+	// Libc is "glibc" or "musl" on Linux.
+	Libc string
+	// Container is the container runtime, e.g. "docker", "podman" or "kubernetes".
+	Container string
+	WSL       bool
+	SSH       bool
+}
+
+// Summary returns a compact one-line description for the prompt.
+func (s SystemInfo) Summary() string {
+	parts := []string{s.OS}
+	if s.Distribution != "" {
+		parts = append(parts, s.Distribution)
+	}
+	if s.Kernel != "" {
+		parts = append(parts, "kernel "+s.Kernel)
+	}
+	parts = append(parts, s.Architecture)
+	if s.Libc != "" {
+		parts = append(parts, s.Libc)
+	}
+	if s.Container != "" {
+		parts = append(parts, "inside a "+s.Container+" container")
+	}
+	if s.WSL {
+		parts = append(parts, "WSL")
+	}
+	if s.SSH {
+		parts = append(parts, "over SSH")
+	}
+	return strings.Join(parts, ", ")
+}
+
+func gatherSystemInfo(request ContextRequest) (interface{}, error) {
+	return detectSystemInfo().Summary(), nil
+}
+
+func detectSystemInfo() SystemInfo {
+	info := detectOSReleaseCached()
+	info.OS = runtime.GOOS
+	info.Architecture = runtime.GOARCH
+	info.SSH = os.Getenv("SSH_CONNECTION") != "" || os.Getenv("SSH_TTY") != ""
+	if runtime.GOOS == "linux" {
+		info.Container = detectContainer()
+		info.WSL = detectWSL()
+	}
+	return info
+}
+
+// osReleaseFiles change when the OS is upgraded.
+var osReleaseFiles = []string{"/etc/os-release", "/usr/lib/os-release", "/System/Library/CoreServices/SystemVersion.plist"}
+
+// detectOSReleaseCached returns the distribution, kernel and libc, which take a few subprocesses
+// to find. They are cached until the next boot, or until the OS release files change.
+func detectOSReleaseCached() SystemInfo {
+	bootTime, err := host.BootTime()
+	if err != nil {
+		return detectOSRelease()
+	}
+	key := fmt.Sprintf("%s boot@%d", runtime.GOOS, bootTime)
+	for _, path := range osReleaseFiles {
+		if info, err := os.Stat(path); err == nil {
+			key += fmt.Sprintf(" %s@%d", path, info.ModTime().UnixNano())
+		}
+	}
+
+	var info SystemInfo
+	if readCache("os-release", key, environmentCacheTTL, &info) {
+		return info
+	}
+	info = detectOSRelease()
+	writeCache("os-release", key, info)
+	return info
+}
+
+func detectOSRelease() SystemInfo {
+	var info SystemInfo
+	switch runtime.GOOS {
+	case "linux":
+		osRelease := readOSRelease()
+		info.Distribution = firstNonEmpty(osRelease["PRETTY_NAME"], osRelease["NAME"])
+		info.Kernel = commandOutput("uname", "-r")
+		info.Libc = detectLibc()
+	case "darwin":
+		if version := commandOutput("sw_vers", "-productVersion"); version != "" {
+			info.Distribution = "macOS " + version
+		}
+		info.Kernel = commandOutput("uname", "-r")
+	case "windows":
+		info.Distribution = commandOutput("cmd", "/C", "ver")
+	}
+	return info
+}
+
+func detectLibc() string {
+	if matches, _ := filepath.Glob("/lib/ld-musl-*"); len(matches) > 0 {
+		return "musl"
+	}
+	output, _ := exec.Command("ldd", "--version").CombinedOutput()
+	lowerOutput := strings.ToLower(string(output))
+	switch {
+	case strings.Contains(lowerOutput, "musl"):
+		return "musl"
+	case strings.Contains(lowerOutput, "glibc"), strings.Contains(lowerOutput, "gnu libc"):
+		return "glibc"
+	}
+	return ""
+}
+
+func detectContainer() string {
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		return "docker"
+	}
+	if _, err := os.Stat("/run/.containerenv"); err == nil {
+		return "podman"
+	}
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return "kubernetes"
+	}
+	if container := os.Getenv("container"); container != "" {
+		return container
+	}
+
+	cgroup, err := ioutil.ReadFile("/proc/1/cgroup")
+	if err != nil {
+		return ""
+	}
+	for _, name := range []string{"kubepods", "docker", "containerd", "lxc"} {
+		if strings.Contains(string(cgroup), name) {
+			if name == "kubepods" {
+				return "kubernetes"
+			}
+			return name
+		}
+	}
+	return ""
+}
+
+func detectWSL() bool {
+	if os.Getenv("WSL_DISTRO_NAME") != "" {
+		return true
+	}
+	version, err := ioutil.ReadFile("/proc/version")
+	return err == nil && strings.Contains(strings.ToLower(string(version)), "microsoft")
+}
+
+// commandOutput returns the trimmed output of a command, or an empty string if it fails.
+func commandOutput(name string, args ...string) string {
+	output, err := exec.Command(name, args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tool is an installed command line tool.
+type Tool struct {
+	// Name is the binary that was found, such as fdfind for fd on Debian.
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type toolCheck struct {
+	// Binaries are alternative names of the tool, tried in order; the first one found is used.
+	Binaries    []string
+	VersionArgs []string
+}
+
+var toolChecks = []toolCheck{
+	{Binaries: []string{"git"}, VersionArgs: []string{"--version"}},
+	{Binaries: []string{"gh"}, VersionArgs: []string{"--version"}},
+	{Binaries: []string{"rg"}, VersionArgs: []string{"--version"}},
+	{Binaries: []string{"fd", "fdfind"}, VersionArgs: []string{"--version"}},
+	{Binaries: []string{"jq"}, VersionArgs: []string{"--version"}},
+	{Binaries: []string{"yq"}, VersionArgs: []string{"--version"}},
+	{Binaries: []string{"aws"}, VersionArgs: []string{"--version"}},
+	{Binaries: []string{"kubectl"}, VersionArgs: []string{"version", "--client"}},
+	{Binaries: []string{"docker"}, VersionArgs: []string{"--version"}},
+	{Binaries: []string{"podman"}, VersionArgs: []string{"--version"}},
+	{Binaries: []string{"python3", "python"}, VersionArgs: []string{"--version"}},
+	{Binaries: []string{"node"}, VersionArgs: []string{"--version"}},
+}
+
+const (
+	toolsCacheTTL = 24 * time.Hour
+	toolsTimeout  = 3 * time.Second
+)
+
+var versionRegex = regexp.MustCompile(`\d+\.\d+(\.\d+)?`)
+
+func gatherTools(request ContextRequest) (interface{}, error) {
+	return detectToolsCached(), nil
+}
+
+// detectToolsCached returns the tool inventory from the cache, unless it expired or PATH changed.
+func detectToolsCached() []Tool {
+	key := toolsCacheKey()
+	var tools []Tool
+	if readCache("tools", key, toolsCacheTTL, &tools) {
+		return tools
+	}
+	tools = detectTools()
+	writeCache("tools", key, tools)
+	return tools
+}
+
+func toolsCacheKey() string {
+	hash := sha256.New()
+	hash.Write([]byte(os.Getenv("PATH")))
+	for _, check := range toolChecks {
+		hash.Write([]byte("\x00" + strings.Join(check.Binaries, ",")))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// detectTools looks up the tools on PATH and asks them for their version, concurrently.
+func detectTools() []Tool {
+	results := make([]*Tool, len(toolChecks))
+	var wg sync.WaitGroup
+	for i, check := range toolChecks {
+		wg.Add(1)
+		go func(i int, check toolCheck) {
+			defer wg.Done()
+			for _, binary := range check.Binaries {
+				path, err := exec.LookPath(binary)
+				if err != nil {
+					continue
+				}
+				results[i] = &Tool{Name: binary, Version: toolVersion(path, check.VersionArgs)}
+				return
+			}
+		}(i, check)
+	}
+	wg.Wait()
+
+	tools := []Tool{}
+	for _, tool := range results {
+		if tool != nil {
+			tools = append(tools, *tool)
+		}
+	}
+	return tools
+}
+
+func toolVersion(path string, args []string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), toolsTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, path, args...).CombinedOutput()
+	if err != nil && len(output) == 0 {
+		return ""
+	}
+	firstLine := strings.SplitN(string(output), "\n", 2)[0]
+	return versionRegex.FindString(firstLine)
+}
@@ -0,0 +1,627 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// UndoSnapshot holds copies of the paths an executed command modifies, taken before it ran.
+// It is stored in a directory under snapshotsDir, with the copies next to snapshot.json.
+type UndoSnapshot struct {
+	Time    time.Time   `json:"time"`
+	Command string      `json:"command"`
+	Cwd     string      `json:"cwd"`
+	Entries []UndoEntry `json:"entries"`
+	dir     string
+}
+
+type UndoEntry struct {
+	// Path is absolute. Paths that didn't exist are removed on undo.
+	Path    string `json:"path"`
+	Existed bool   `json:"existed"`
+	// Copy is the name of the copy within the snapshot directory.
+	Copy string `json:"copy,omitempty"`
+}
+
+const (
+	defaultUndoKeep = 10
+	defaultUndoDays = 7
+	// undoMaxSize is the total size of the copies in a snapshot. Larger paths are not copied.
+	undoMaxSize = 512 * 1024 * 1024
+)
+
+var snapshotsDir = filepath.Join(stateDir, "snapshots")
+
+// modifyingCommands maps commands to the arguments they modify: "all" of them, the "last" one,
+// or the "files" after a script or mode.
+var modifyingCommands = map[string]string{
+	"mv": "all", "rm": "all", "rmdir": "all", "touch": "all", "mkdir": "all", "truncate": "all",
+	"sed": "files", "perl": "files", "chmod": "files", "chown": "files", "chgrp": "files",
+	"tee": "all", "cp": "last", "ln": "last", "rsync": "last", "install": "last", "rename": "all",
+	"shred": "all",
+}
+
+var variableRegex = regexp.MustCompile(`\$(\w+)|\$\{(\w+)(%%|%|##|#)?([^}]*)\}`)
+
+// inferModifiedPaths finds the paths a command modifies by parsing it, to check the paths that the
+// model declared. It also reports whether there are arguments it couldn't resolve, such as
+// variables, command substitutions or paths after a cd to an unknown directory. The variable of a
+// for loop over files is resolved. Paths after a cd are joined to its directory.
+func inferModifiedPaths(command string, workingDirectory string) (paths []string, unresolved bool) {
+	var loops []forLoop
+	directory := workingDirectory
+	for _, segment := range splitCommand(command) {
+		words := segment.Words
+		if len(words) > 0 && words[0] == "done" && len(loops) > 0 {
+			loops = loops[:len(loops)-1]
+			words = words[1:]
+		}
+		if len(words) >= 3 && words[0] == "for" && words[2] == "in" {
+			loops = append(loops, forLoop{variable: words[1], values: expandGlobs(words[3:])})
+			continue
+		}
+		for len(words) > 0 && (contains(shellKeywords, words[0]) || words[0] == "sudo" || words[0] == "doas" || words[0] == "command" || strings.Contains(words[0], "=") && !strings.HasPrefix(words[0], "-")) {
+			words = words[1:]
+		}
+		var segmentPaths []string
+		var targets []string
+		for i := 0; i < len(words); i++ {
+			// Redirections: > file, >> file, 2>file, &>file.
+			word := strings.TrimLeft(words[i], "0123456789&")
+			if strings.HasPrefix(word, ">") {
+				target := strings.TrimLeft(word, ">|")
+				if (target == "" || target == "&") && i+1 < len(words) {
+					i++
+					target = words[i]
+				}
+				if target != "" && !strings.HasPrefix(target, "&") && target != "/dev/null" {
+					segmentPaths = append(segmentPaths, target)
+				}
+				continue
+			}
+			targets = append(targets, words[i])
+		}
+		if len(targets) > 0 {
+			switch name := filepath.Base(targets[0]); name {
+			case "cd", "pushd":
+				directory = changeDirectory(nonFlags(targets[1:]), directory)
+			default:
+				segmentPaths = append(segmentPaths, commandModifiedPaths(name, targets[1:])...)
+			}
+		}
+
+		for _, path := range segmentPaths {
+			values := []string{path}
+			if strings.ContainsAny(path, "$`") {
+				var ok bool
+				values, ok = substituteLoopVariables(path, loops)
+				if !ok {
+					unresolved = true
+				}
+			}
+			for _, value := range values {
+				switch {
+				case directory == workingDirectory || filepath.IsAbs(value) || value == "~" || strings.HasPrefix(value, "~/"):
+					paths = append(paths, value)
+				case directory == "":
+					unresolved = true
+				default:
+					paths = append(paths, filepath.Join(directory, value))
+				}
+			}
+		}
+	}
+	return paths, unresolved
+}
+
+// commandModifiedPaths returns the paths that a command modifies, given its arguments.
+func commandModifiedPaths(name string, args []string) []string {
+	operands := nonFlags(args)
+	switch modifyingCommands[name] {
+	case "all":
+		return operands
+	case "last":
+		if len(operands) > 0 {
+			return operands[len(operands)-1:]
+		}
+	case "files":
+		// The first argument is the script or mode; sed and perl only modify with -i.
+		if (name == "sed" || name == "perl") && !hasFlag(args, "i", "-in-place") {
+			return nil
+		}
+		if len(operands) > 1 {
+			return operands[1:]
+		}
+	}
+
+	switch name {
+	case "find":
+		if contains(args, "-delete") {
+			// The starting points come before the first expression.
+			var starts []string
+			for _, arg := range args {
+				if strings.HasPrefix(arg, "-") || arg == "!" {
+					break
+				}
+				starts = append(starts, arg)
+			}
+			if len(starts) == 0 {
+				starts = []string{"."}
+			}
+			return starts
+		}
+	case "git":
+		if len(operands) == 0 {
+			return nil
+		}
+		switch operands[0] {
+		case "rm", "restore":
+			return operands[1:]
+		case "checkout":
+			// Without --, the arguments may be branches; only the ones that exist are paths.
+			for i, arg := range args {
+				if arg == "--" {
+					return args[i+1:]
+				}
+			}
+			var existing []string
+			for _, operand := range operands[1:] {
+				if _, err := os.Lstat(operand); err == nil {
+					existing = append(existing, operand)
+				}
+			}
+			return existing
+		}
+	case "sort":
+		for i, arg := range args {
+			switch {
+			case (arg == "-o" || arg == "--output") && i+1 < len(args):
+				return args[i+1 : i+2]
+			case strings.HasPrefix(arg, "--output="):
+				return []string{strings.TrimPrefix(arg, "--output=")}
+			case strings.HasPrefix(arg, "-o") && !strings.HasPrefix(arg, "--"):
+				return []string{arg[2:]}
+			}
+		}
+	case "tar", "bsdtar", "gtar":
+		return tarExtractedPaths(args)
+	}
+	return nil
+}
+
+// tarExtractedPaths lists the top level paths that tar x would extract, by listing the archive.
+func tarExtractedPaths(args []string) []string {
+	if len(args) == 0 {
+		return nil
+	}
+	// The first argument may be bundled options without a dash, as in tar xzf archive.tar.gz.
+	options := args[0]
+	rest := args[1:]
+	if strings.HasPrefix(options, "-") {
+		options, rest = "", args
+	}
+	extract := strings.Contains(options, "x") || hasFlag(rest, "x", "-extract", "-get")
+	var archive, directory string
+	if strings.Contains(options, "f") && len(nonFlags(rest)) > 0 {
+		archive = nonFlags(rest)[0]
+	}
+	for i, arg := range rest {
+		next := ""
+		if i+1 < len(rest) {
+			next = rest[i+1]
+		}
+		switch {
+		case strings.HasPrefix(arg, "--file="):
+			archive = strings.TrimPrefix(arg, "--file=")
+		case arg == "--file" || strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.HasSuffix(arg, "f"):
+			archive = next
+		case strings.HasPrefix(arg, "--directory="):
+			directory = strings.TrimPrefix(arg, "--directory=")
+		case arg == "-C" || arg == "--directory":
+			directory = next
+		}
+	}
+	if !extract || archive == "" || archive == "-" {
+		return nil
+	}
+
+	output, err := exec.Command("tar", "-tf", archive).Output()
+	if err != nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	var paths []string
+	for _, entry := range strings.Split(string(output), "\n") {
+		top := strings.SplitN(strings.TrimPrefix(entry, "./"), "/", 2)[0]
+		if top == "" || top == "." || seen[top] {
+			continue
+		}
+		seen[top] = true
+		paths = append(paths, filepath.Join(directory, top))
+	}
+	return paths
+}
+
+// forLoop is a for loop that encloses a command, with the values of its variable.
+type forLoop struct {
+	variable string
+	values   []string
+}
+
+// substituteLoopVariables expands the variables of the enclosing loops in a path, including the
+// ${f%.jpeg} forms that strip a suffix or prefix. It returns false when the path has other
+// variables, or command substitutions.
+func substituteLoopVariables(path string, loops []forLoop) ([]string, bool) {
+	if strings.Contains(path, "`") || strings.Contains(path, "$(") {
+		return nil, false
+	}
+	matches := variableRegex.FindAllStringSubmatch(path, -1)
+	var loop *forLoop
+	for _, match := range matches {
+		name := match[1] + match[2]
+		found := false
+		for i := len(loops) - 1; i >= 0; i-- {
+			if loops[i].variable == name {
+				if loop != nil && loop != &loops[i] {
+					return nil, false
+				}
+				loop, found = &loops[i], true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	if loop == nil {
+		return nil, false
+	}
+
+	var paths []string
+	for _, value := range loop.values {
+		paths = append(paths, variableRegex.ReplaceAllStringFunc(path, func(reference string) string {
+			match := variableRegex.FindStringSubmatch(reference)
+			return trimPattern(value, match[3], match[4])
+		}))
+	}
+	return paths, true
+}
+
+// trimPattern applies the ${value%pattern} family of operators.
+func trimPattern(value, operator, pattern string) string {
+	matches := func(s string) bool {
+		matched, _ := path.Match(pattern, s)
+		return matched
+	}
+	switch operator {
+	case "%":
+		for i := len(value); i >= 0; i-- {
+			if matches(value[i:]) {
+				return value[:i]
+			}
+		}
+	case "%%":
+		for i := 0; i <= len(value); i++ {
+			if matches(value[i:]) {
+				return value[:i]
+			}
+		}
+	case "#":
+		for i := 0; i <= len(value); i++ {
+			if matches(value[:i]) {
+				return value[i:]
+			}
+		}
+	case "##":
+		for i := len(value); i >= 0; i-- {
+			if matches(value[:i]) {
+				return value[i:]
+			}
+		}
+	}
+	return value
+}
+
+// expandGlobs expands the words that are globs, like the shell. Globs without matches are kept.
+func expandGlobs(words []string) []string {
+	var expanded []string
+	for _, word := range words {
+		if matches, _ := filepath.Glob(word); strings.ContainsAny(word, "*?[") && len(matches) > 0 {
+			expanded = append(expanded, matches...)
+			continue
+		}
+		expanded = append(expanded, word)
+	}
+	return expanded
+}
+
+// takeUndoSnapshot copies the paths that the command modifies, as declared by the model and as
+// found by parsing the command. Failures are reported, but don't stop the command.
+func takeUndoSnapshot(command string, declared []string, config Config) {
+	workingDirectory, _ := os.Getwd()
+	inferred, unresolved := inferModifiedPaths(command, workingDirectory)
+
+	declaredPaths := expandSnapshotPaths(declared, workingDirectory)
+	var undeclared []string
+	for _, path := range inferred {
+		for _, absolute := range expandSnapshotPath(path, workingDirectory) {
+			if !coveredBy(absolute, declaredPaths) {
+				undeclared = append(undeclared, path)
+				break
+			}
+		}
+	}
+	if len(undeclared) > 0 {
+		color.Yellow("The command also modifies paths the model didn't declare: %s", strings.Join(undeclared, ", "))
+	}
+	if unresolved {
+		color.Yellow("Can't tell from the command which paths it modifies; undo may be incomplete.")
+	}
+
+	paths := expandSnapshotPaths(append(append([]string{}, declared...), inferred...), workingDirectory)
+	if len(paths) == 0 {
+		return
+	}
+
+	snapshot := UndoSnapshot{Time: time.Now(), Command: command, Cwd: workingDirectory}
+	snapshot.dir = filepath.Join(snapshotsDir, snapshot.Time.Format("20060102-150405.000000000"))
+	if err := os.MkdirAll(snapshot.dir, 0700); err != nil {
+		color.Yellow("Can't snapshot for undo: %v", err)
+		return
+	}
+
+	var size int64
+	for i, path := range paths {
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			snapshot.Entries = append(snapshot.Entries, UndoEntry{Path: path})
+			continue
+		}
+		if err != nil {
+			color.Yellow("Can't snapshot %s for undo: %v", path, err)
+			continue
+		}
+		entrySize := pathSize(path, info, undoMaxSize-size)
+		if entrySize > undoMaxSize-size {
+			color.Yellow("Not snapshotting %s for undo: the snapshot would exceed %s", path, formatSize(undoMaxSize))
+			continue
+		}
+		size += entrySize
+		entry := UndoEntry{Path: path, Existed: true, Copy: fmt.Sprint(i)}
+		if err := copyPath(path, filepath.Join(snapshot.dir, entry.Copy), info); err != nil {
+			color.Yellow("Can't snapshot %s for undo: %v", path, err)
+			continue
+		}
+		snapshot.Entries = append(snapshot.Entries, entry)
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(snapshot.dir, "snapshot.json"), data, 0600)
+	}
+	if err != nil {
+		color.Yellow("Can't snapshot for undo: %v", err)
+		os.RemoveAll(snapshot.dir)
+		return
+	}
+	color.New(color.Faint).Printf("Saved %d paths for ai undo.\n", len(snapshot.Entries))
+	pruneUndoSnapshots(config)
+}
+
+// expandSnapshotPaths makes the paths absolute, expands globs and removes duplicates. Paths
+// inside another path of the list are left out, as they are copied along with it.
+func expandSnapshotPaths(paths []string, workingDirectory string) []string {
+	seen := map[string]bool{}
+	var expanded []string
+	for _, path := range paths {
+		for _, match := range expandSnapshotPath(path, workingDirectory) {
+			if !seen[match] {
+				seen[match] = true
+				expanded = append(expanded, match)
+			}
+		}
+	}
+
+	sort.Strings(expanded)
+	var result []string
+	for _, path := range expanded {
+		if !coveredBy(path, result) {
+			result = append(result, path)
+		}
+	}
+	return result
+}
+
+// expandSnapshotPath makes a path absolute and clean, and expands it if it is a glob.
+func expandSnapshotPath(path string, workingDirectory string) []string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		path = filepath.Join(homeDir, strings.TrimPrefix(path[1:], "/"))
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workingDirectory, path)
+	}
+	path = filepath.Clean(path)
+	if strings.ContainsAny(path, "*?[") {
+		matches, _ := filepath.Glob(path)
+		return matches
+	}
+	return []string{path}
+}
+
+// coveredBy tells whether a path is one of the paths, or inside one of them.
+func coveredBy(path string, paths []string) bool {
+	for _, other := range paths {
+		if path == other || strings.HasPrefix(path, strings.TrimSuffix(other, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathSize returns the size of a file or directory tree. It stops walking the tree once the size
+// exceeds limit, so the result is only exact up to limit.
+func pathSize(path string, info os.FileInfo, limit int64) int64 {
+	if !info.IsDir() {
+		return info.Size()
+	}
+	var size int64
+	filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		if size > limit {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return size
+}
+
+// copyPath copies a file, symlink or directory tree.
+func copyPath(src, dst string, info os.FileInfo) error {
+	switch {
+	case info.IsDir():
+		return copyTree(src, dst)
+	case info.Mode()&fs.ModeSymlink != 0:
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, dst)
+	default:
+		if err := copyFile(src, dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+		return os.Chtimes(dst, info.ModTime(), info.ModTime())
+	}
+}
+
+// readUndoSnapshots returns the snapshots, newest first.
+func readUndoSnapshots() []UndoSnapshot {
+	dirs, _ := ioutil.ReadDir(snapshotsDir)
+	var snapshots []UndoSnapshot
+	for _, dir := range dirs {
+		path := filepath.Join(snapshotsDir, dir.Name())
+		data, err := ioutil.ReadFile(filepath.Join(path, "snapshot.json"))
+		if err != nil {
+			continue
+		}
+		var snapshot UndoSnapshot
+		if json.Unmarshal(data, &snapshot) != nil {
+			continue
+		}
+		snapshot.dir = path
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Time.After(snapshots[j].Time) })
+	return snapshots
+}
+
+// pruneUndoSnapshots keeps the newest undo_keep snapshots that are at most undo_days old.
+func pruneUndoSnapshots(config Config) {
+	keep := config.UndoKeep
+	if keep <= 0 {
+		keep = defaultUndoKeep
+	}
+	days := config.UndoDays
+	if days <= 0 {
+		days = defaultUndoDays
+	}
+	cutoff := time.Now().AddDate(0, 0, -days)
+	for i, snapshot := range readUndoSnapshots() {
+		if i >= keep || snapshot.Time.Before(cutoff) {
+			os.RemoveAll(snapshot.dir)
+		}
+	}
+}
+
+func runUndo(args []string, config Config, model string) {
+	flags := newSubcommandFlagSet("undo")
+	listFlag := flags.Bool("list", false, "List the snapshots instead of restoring one")
+	yesFlag := flags.Bool("yes", false, "Restore without asking")
+	flags.Parse(args)
+
+	snapshots := readUndoSnapshots()
+	if *listFlag {
+		for _, snapshot := range snapshots {
+			fmt.Printf("%s  %s  (%d paths in %s)\n", snapshot.Time.Local().Format("2006-01-02 15:04:05"), snapshot.Command, len(snapshot.Entries), snapshot.Cwd)
+		}
+		return
+	}
+	if len(snapshots) == 0 {
+		fmt.Println("Nothing to undo.")
+		return
+	}
+
+	snapshot := snapshots[0]
+	fmt.Printf("Undo %s, run %s in %s:\n", color.BlueString(snapshot.Command), snapshot.Time.Local().Format("2006-01-02 15:04:05"), snapshot.Cwd)
+	for _, entry := range snapshot.Entries {
+		if entry.Existed {
+			color.Yellow("  restore  %s", entry.Path)
+		} else {
+			color.Red("  remove   %s", entry.Path)
+		}
+	}
+	if !*yesFlag {
+		answer, err := readTerminalLine("Continue? [y/N] ")
+		if err != nil || (answer != "y" && answer != "yes") {
+			return
+		}
+	}
+
+	failed := false
+	for _, entry := range snapshot.Entries {
+		if err := restoreUndoEntry(snapshot, entry); err != nil {
+			color.Red("Can't restore %s: %v", entry.Path, err)
+			failed = true
+		}
+	}
+	if failed {
+		log.Fatalf("Some paths were not restored; the snapshot is kept in %s", snapshot.dir)
+	}
+	os.RemoveAll(snapshot.dir)
+	color.Green("Restored %d paths.", len(snapshot.Entries))
+}
+
+// restoreUndoEntry copies the stored path next to the current one and only then swaps it in, so
+// that a failed copy leaves the current path in place. The snapshot itself is left intact, so that
+// a failed undo can be retried.
+func restoreUndoEntry(snapshot UndoSnapshot, entry UndoEntry) error {
+	if !entry.Existed {
+		return os.RemoveAll(entry.Path)
+	}
+	stored := filepath.Join(snapshot.dir, entry.Copy)
+	info, err := os.Lstat(stored)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(entry.Path), 0755); err != nil {
+		return err
+	}
+	temp := filepath.Join(filepath.Dir(entry.Path), fmt.Sprintf(".%s.ai-undo-%d", filepath.Base(entry.Path), os.Getpid()))
+	os.RemoveAll(temp)
+	if err := copyPath(stored, temp, info); err != nil {
+		os.RemoveAll(temp)
+		return err
+	}
+	if err := os.RemoveAll(entry.Path); err != nil {
+		os.RemoveAll(temp)
+		return err
+	}
+	return os.Rename(temp, entry.Path)
+}
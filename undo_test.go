@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestInferModifiedPaths(t *testing.T) {
+	tests := []struct {
+		command    string
+		paths      []string
+		unresolved bool
+	}{
+		{`rm -rf data`, []string{"data"}, false},
+		{`mv a.txt b.txt`, []string{"a.txt", "b.txt"}, false},
+		{`cp -r src dst`, []string{"dst"}, false},
+		{`sed -i 's/a/b/' config.yaml`, []string{"config.yaml"}, false},
+		{`sed 's/a/b/' config.yaml`, nil, false},
+		{`rm $FILE`, nil, true},
+
+		// Redirections, also when written within a word.
+		{`echo hi > out.txt`, []string{"out.txt"}, false},
+		{`echo hi>out.txt`, []string{"out.txt"}, false},
+		{`echo hi>>out.txt`, []string{"out.txt"}, false},
+		{`make 2>err.log`, []string{"err.log"}, false},
+		{`make &>build.log`, []string{"build.log"}, false},
+		{`make 2>&1 >/dev/null`, nil, false},
+		{`echo "a>b"`, nil, false},
+
+		// cd changes what relative paths refer to.
+		{`cd sub && rm -rf data`, []string{"/home/user/project/sub/data"}, false},
+		{`cd /tmp && rm -rf data`, []string{"/tmp/data"}, false},
+		{`cd sub && echo hi>out.txt`, []string{"/home/user/project/sub/out.txt"}, false},
+		{`cd "$DIR" && rm -rf data`, nil, true},
+		{`cd "$DIR" && rm -rf /tmp/data`, []string{"/tmp/data"}, false},
+	}
+	for _, test := range tests {
+		paths, unresolved := inferModifiedPaths(test.command, "/home/user/project")
+		if !reflect.DeepEqual(paths, test.paths) || unresolved != test.unresolved {
+			t.Errorf("inferModifiedPaths(%q) = %q, %v, want %q, %v", test.command, paths, unresolved, test.paths, test.unresolved)
+		}
+	}
+}
+
+func TestPathSizeStopsAtLimit(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c", "d"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), make([]byte, 100), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := pathSize(dir, info, 1000); size != 400 {
+		t.Errorf("pathSize within the limit = %d, want 400", size)
+	}
+	if size := pathSize(dir, info, 150); size != 200 {
+		t.Errorf("pathSize over the limit = %d, want 200, where the walk stops", size)
+	}
+}
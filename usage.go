@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// UsageRecord is one line of the usage log: which key served a request, and what it cost.
+type UsageRecord struct {
+	Time             time.Time `json:"time"`
+	Key              string    `json:"key"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	Cost             float64   `json:"cost"`
+}
+
+var usageFilePath = filepath.Join(stateDir, "usage.jsonl")
+
+// defaultModelPrices are in USD per million tokens. Models are matched by the longest prefix.
+var defaultModelPrices = map[string]ModelPrice{
+	"gpt-4o":        {Input: 2.5, Output: 10},
+	"gpt-4o-mini":   {Input: 0.15, Output: 0.6},
+	"gpt-4-turbo":   {Input: 10, Output: 30},
+	"gpt-4":         {Input: 30, Output: 60},
+	"gpt-3.5-turbo": {Input: 0.5, Output: 1.5},
+}
+
+func modelPrice(model string) ModelPrice {
+	prices := map[string]ModelPrice{}
+	for name, price := range defaultModelPrices {
+		prices[name] = price
+	}
+	for name, price := range readConfig().Prices {
+		prices[name] = price
+	}
+
+	var match string
+	for name := range prices {
+		if strings.HasPrefix(model, name) && len(name) > len(match) {
+			match = name
+		}
+	}
+	return prices[match]
+}
+
+func recordUsage(key, model string, usage openai.Usage) {
+	price := modelPrice(model)
+	record := UsageRecord{
+		Time:             time.Now(),
+		Key:              key,
+		Model:            model,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		Cost:             (float64(usage.PromptTokens)*price.Input + float64(usage.CompletionTokens)*price.Output) / 1e6,
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(stateDir, 0700)
+	if err == nil {
+		var file *os.File
+		file, err = os.OpenFile(usageFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err == nil {
+			defer file.Close()
+			_, err = file.Write(append(line, '\n'))
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not record API usage: %v\n", err)
+	}
+}
+
+// monthlySpend returns the estimated spend of a key in the current calendar month.
+func monthlySpend(key string) float64 {
+	file, err := os.Open(usageFilePath)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	now := time.Now()
+	var spend float64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record UsageRecord
+		if json.Unmarshal(scanner.Bytes(), &record) != nil {
+			continue
+		}
+		if record.Key == key && record.Time.Year() == now.Year() && record.Time.Month() == now.Month() {
+			spend += record.Cost
+		}
+	}
+	return spend
+}